@@ -0,0 +1,76 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+)
+
+// defaultAdminAddr is where the admin listener binds outside production when
+// -admin-addr isn't set. It is loopback only, so that reaching it needs
+// access to the host.
+const defaultAdminAddr = "localhost:4001"
+
+// adminAddr returns the address the admin listener should bind to, or ""
+// when it is disabled. It is off in production unless an address is given.
+func adminAddr(cfg config) string {
+	switch {
+	case cfg.admin.addr == "none":
+		return ""
+	case cfg.admin.addr != "":
+		return cfg.admin.addr
+	case cfg.env == "production":
+		return ""
+	default:
+		return defaultAdminAddr
+	}
+}
+
+// adminRoutes serves the metrics and debugging endpoints, which reveal too
+// much about the process to be on the public port. They have no
+// authentication of their own; access is controlled by where the admin
+// listener binds, so scraping metrics in production needs -admin-addr.
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/metrics", app.metricsHandler)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("/debug/goroutines", app.goroutinesHandler)
+	mux.HandleFunc("/debug/readyz", app.debugReadyzHandler)
+	mux.HandleFunc("/debug/config", app.configHandler)
+
+	return app.recoverPanic(mux)
+}
+
+// goroutinesHandler writes the stack of every goroutine as plain text, in
+// the same format as an unrecovered panic.
+func (app *application) goroutinesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	err := runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// configHandler shows the settings the server started with, with secrets
+// redacted, and the reloadable settings currently in effect.
+func (app *application) configHandler(w http.ResponseWriter, r *http.Request) {
+	env := envelope{
+		"startup": app.startupSettings,
+		"live":    reloadableSettings(app.liveConfig()),
+	}
+
+	err := app.writeResponse(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
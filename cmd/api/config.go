@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"greenlight.bcc/internal/jsonlog"
+	"greenlight.bcc/internal/validator"
+)
+
+// envPrefix is prepended to a setting's name, upper-cased with dashes turned
+// into underscores, to find its environment variable: db-dsn is read from
+// GREENLIGHT_DB_DSN.
+const envPrefix = "GREENLIGHT_"
+
+// secretSettings are never written out in full by -print-config or the
+// startup log.
+var secretSettings = map[string]bool{
+	"db-dsn":        true,
+	"smtp-username": true,
+	"smtp-password": true,
+}
+
+// settingsOnly are flags that pick a mode for this run rather than configure
+// the server, so they can't come from the config file or environment.
+var settingsOnly = map[string]bool{
+	"config":       true,
+	"print-config": true,
+	"migrate":      true,
+}
+
+// stringList is a flag.Value holding a space separated list.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Set(val string) error {
+	*l = strings.Fields(val)
+	return nil
+}
+
+// newFlagSet registers every setting in cfg as a flag. The flag defaults are
+// the bottom layer of the configuration.
+func newFlagSet(cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+
+	fs.StringVar(&cfg.file, "config", "", "Path to a JSON config file (also GREENLIGHT_CONFIG)")
+	fs.BoolVar(&cfg.printConfig, "print-config", false, "Print the effective configuration, with secrets redacted, and exit")
+
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	fs.BoolVar(&cfg.requireIfMatch, "require-if-match", false, "Refuse movie updates and deletes without an If-Match header")
+
+	cfg.logLevel = jsonlog.LevelInfo
+	fs.Var(&cfg.logLevel, "log-level", "Minimum log level (debug|info|warn|error|fatal|off)")
+	cfg.logStackLevel = jsonlog.LevelError
+	fs.Var(&cfg.logStackLevel, "log-stack-level", "Minimum log level that includes a stack trace (debug|info|warn|error|fatal|off)")
+
+	cfg.logs.outputs = []string{"stdout"}
+	fs.Var((*stringList)(&cfg.logs.outputs), "log-outputs", "Where to write logs (space separated: stdout, file, syslog)")
+	fs.StringVar(&cfg.logs.file, "log-file", "greenlight.log", "File that -log-outputs=file writes to")
+	fs.IntVar(&cfg.logs.maxSize, "log-file-max-size", 100, "Size in megabytes at which the log file is rotated (0 for no limit)")
+	fs.DurationVar(&cfg.logs.rotateInterval, "log-file-rotate-interval", 24*time.Hour, "How often the log file is rotated (0 for never)")
+	fs.IntVar(&cfg.logs.maxBackups, "log-file-max-backups", 7, "Number of rotated log files kept (0 for all)")
+	fs.DurationVar(&cfg.logs.maxAge, "log-file-max-age", 0, "How long rotated log files are kept (0 for ever)")
+	fs.BoolVar(&cfg.logs.compress, "log-file-compress", true, "Gzip rotated log files")
+	fs.StringVar(&cfg.logs.syslogAddress, "log-syslog-address", "", "Syslog socket for -log-outputs=syslog (default: the first of /dev/log, /var/run/syslog and /var/run/log)")
+	fs.StringVar(&cfg.logs.syslogTag, "log-syslog-tag", "greenlight", "Tag for syslog entries")
+	fs.IntVar(&cfg.logs.buffer, "log-buffer", 4096, "Log entries queued for slow outputs before new ones are dropped (0 to write synchronously)")
+	fs.DurationVar(&cfg.logs.sampleTick, "log-sample-tick", time.Second, "Period over which repeated debug and info entries are sampled (0 to log everything)")
+	fs.IntVar(&cfg.logs.sampleFirst, "log-sample-first", 100, "Identical debug and info entries logged per -log-sample-tick before sampling starts")
+	fs.IntVar(&cfg.logs.sampleThereafter, "log-sample-thereafter", 100, "Once sampling has started, log one in this many identical entries (0 to drop them all)")
+
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.alexedwards.net>", "SMTP sender")
+
+	fs.DurationVar(&cfg.idempotency.ttl, "idempotency-key-ttl", 24*time.Hour, "How long responses to requests with an Idempotency-Key are kept for replay")
+
+	fs.DurationVar(&cfg.stats.cacheTTL, "stats-cache-ttl", 30*time.Second, "How long catalogue statistics are cached for")
+
+	fs.StringVar(&cfg.migrate.action, "migrate", "", "Run database migrations and exit (up|down|status|to N)")
+	fs.BoolVar(&cfg.migrate.check, "migrate-check", false, "Refuse to start if the database schema is behind the embedded migrations")
+
+	fs.StringVar(&cfg.trace.exporter, "trace-exporter", "none", "Where to send trace spans (none|otlp|file)")
+	fs.StringVar(&cfg.trace.endpoint, "trace-otlp-endpoint", "http://localhost:4318/v1/traces", "OTLP/HTTP traces endpoint for -trace-exporter=otlp")
+	fs.StringVar(&cfg.trace.file, "trace-file", "traces.jsonl", "File that -trace-exporter=file appends spans to")
+
+	fs.StringVar(&cfg.admin.addr, "admin-addr", "", "Address of the admin listener serving /metrics and /debug endpoints, or none to disable it (default "+defaultAdminAddr+", or none with -env=production)")
+
+	fs.DurationVar(&cfg.health.timeout, "health-check-timeout", 2*time.Second, "How long each /readyz dependency check may take")
+	fs.DurationVar(&cfg.health.cacheTTL, "health-cache-ttl", time.Second, "How long /readyz reuses the results of its dependency checks")
+	fs.IntVar(&cfg.health.maxBackgroundTasks, "health-max-background-tasks", 100, "Running background tasks above which /readyz reports not ready (0 for no limit)")
+	fs.DurationVar(&cfg.shutdownDelay, "shutdown-delay", 0, "How long to report not ready before shutting down, so that load balancers stop sending traffic first")
+
+	fs.Var((*stringList)(&cfg.cors.trustedOrigins), "cors-trusted-origins", "Trusted CORS origins (space separated)")
+
+	return fs
+}
+
+// loadConfig builds the configuration from, lowest precedence first, the flag
+// defaults, the JSON config file, GREENLIGHT_* environment variables and the
+// command-line flags, then validates it. Any setting can instead be read from
+// a file named by a "<name>-file" key in the config file or a
+// GREENLIGHT_<NAME>_FILE variable, which is how secrets are expected to be
+// passed in production. The returned FlagSet holds the remaining arguments.
+func loadConfig(args []string, lookupEnv func(string) (string, bool)) (config, *flag.FlagSet, error) {
+	var cfg config
+
+	fs := newFlagSet(&cfg)
+	fs.SetOutput(io.Discard)
+
+	err := fs.Parse(args)
+	if err != nil {
+		return cfg, fs, err
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if cfg.file == "" {
+		cfg.file, _ = lookupEnv(envPrefix + "CONFIG")
+	}
+
+	if cfg.file != "" {
+		settings, err := readConfigFile(cfg.file)
+		if err != nil {
+			return cfg, fs, err
+		}
+
+		err = applySettings(fs, settings, explicit)
+		if err != nil {
+			return cfg, fs, fmt.Errorf("config file %s: %w", cfg.file, err)
+		}
+	}
+
+	err = applyEnv(fs, lookupEnv, explicit)
+	if err != nil {
+		return cfg, fs, err
+	}
+
+	err = validateConfig(cfg)
+	if err != nil {
+		return cfg, fs, err
+	}
+
+	return cfg, fs, nil
+}
+
+// readConfigFile reads a JSON object and flattens it into setting names, so
+// {"db": {"dsn": "..."}} and {"db-dsn": "..."} are equivalent. Lists are
+// joined with spaces.
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+
+	var tree map[string]any
+	err = dec.Decode(&tree)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	settings := make(map[string]string)
+
+	err = flattenSettings("", tree, settings)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return settings, nil
+}
+
+func flattenSettings(prefix string, tree map[string]any, settings map[string]string) error {
+	for key, value := range tree {
+		name := key
+		if prefix != "" {
+			name = prefix + "-" + key
+		}
+
+		switch value := value.(type) {
+		case map[string]any:
+			err := flattenSettings(name, value, settings)
+			if err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			settings[name] = strings.Join(items, " ")
+		case nil:
+			return fmt.Errorf("%s must not be null", name)
+		default:
+			settings[name] = fmt.Sprint(value)
+		}
+	}
+
+	return nil
+}
+
+func applySettings(fs *flag.FlagSet, settings map[string]string, explicit map[string]bool) error {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := settings[name]
+
+		target := name
+		if strings.HasSuffix(name, "-file") && fs.Lookup(name) == nil {
+			target = strings.TrimSuffix(name, "-file")
+
+			secret, err := readSecretFile(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			value = secret
+		}
+
+		if fs.Lookup(target) == nil || settingsOnly[target] {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+
+		if explicit[target] {
+			continue
+		}
+
+		err := fs.Set(target, value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyEnv(fs *flag.FlagSet, lookupEnv func(string) (string, bool), explicit map[string]bool) error {
+	var err error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] || settingsOnly[f.Name] {
+			return
+		}
+
+		key := envName(f.Name)
+
+		value, ok := lookupEnv(key)
+		if path, fileOK := lookupEnv(key + "_FILE"); fileOK {
+			if ok {
+				err = fmt.Errorf("only one of %s and %s_FILE may be set", key, key)
+				return
+			}
+
+			value, err = readSecretFile(path)
+			if err != nil {
+				err = fmt.Errorf("%s_FILE: %w", key, err)
+				return
+			}
+			ok = true
+		}
+
+		if !ok {
+			return
+		}
+
+		setErr := fs.Set(f.Name, value)
+		if setErr != nil {
+			err = fmt.Errorf("%s: %w", key, setErr)
+		}
+	})
+
+	return err
+}
+
+func envName(setting string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+}
+
+// readSecretFile returns the contents of path without the trailing newline
+// that editors and `echo` leave behind.
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+func validateConfig(cfg config) error {
+	v := validator.New()
+
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.PermittedValue(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	v.Check(cfg.db.dsn != "", "db-dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns > 0, "db-max-open-conns", "must be greater than zero")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	_, err := time.ParseDuration(cfg.db.maxIdleTime)
+	v.Check(err == nil, "db-max-idle-time", "must be a duration such as 15m")
+
+	if cfg.limiter.enabled {
+		v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+		v.Check(cfg.limiter.burst > 0, "limiter-burst", "must be greater than zero")
+	}
+
+	v.Check(cfg.smtp.host != "", "smtp-host", "must be provided")
+	v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	_, err = mail.ParseAddress(cfg.smtp.sender)
+	v.Check(err == nil, "smtp-sender", "must be a valid email address")
+
+	v.Check(cfg.idempotency.ttl > 0, "idempotency-key-ttl", "must be greater than zero")
+	v.Check(cfg.stats.cacheTTL >= 0, "stats-cache-ttl", "must not be negative")
+
+	v.Check(validator.PermittedValue(cfg.trace.exporter, "none", "otlp", "file"), "trace-exporter", "must be none, otlp or file")
+	if cfg.trace.exporter == "otlp" {
+		u, err := url.Parse(cfg.trace.endpoint)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "trace-otlp-endpoint", "must be an http or https URL")
+	}
+	if cfg.trace.exporter == "file" {
+		v.Check(cfg.trace.file != "", "trace-file", "must be provided")
+	}
+
+	if cfg.admin.addr != "" && cfg.admin.addr != "none" {
+		_, _, err := net.SplitHostPort(cfg.admin.addr)
+		v.Check(err == nil, "admin-addr", "must be host:port or none")
+	}
+
+	v.Check(cfg.health.timeout > 0, "health-check-timeout", "must be greater than zero")
+	v.Check(cfg.health.cacheTTL >= 0, "health-cache-ttl", "must not be negative")
+	v.Check(cfg.health.maxBackgroundTasks >= 0, "health-max-background-tasks", "must not be negative")
+	v.Check(cfg.shutdownDelay >= 0, "shutdown-delay", "must not be negative")
+
+	v.Check(len(cfg.logs.outputs) > 0, "log-outputs", "must be provided")
+	v.Check(validator.Unique(cfg.logs.outputs), "log-outputs", "must not contain duplicate values")
+	for _, output := range cfg.logs.outputs {
+		v.Check(validator.PermittedValue(output, "stdout", "file", "syslog"), "log-outputs", "must be stdout, file or syslog")
+	}
+	if validator.PermittedValue("file", cfg.logs.outputs...) {
+		v.Check(cfg.logs.file != "", "log-file", "must be provided")
+		v.Check(cfg.logs.maxSize >= 0, "log-file-max-size", "must not be negative")
+		v.Check(cfg.logs.rotateInterval >= 0, "log-file-rotate-interval", "must not be negative")
+		v.Check(cfg.logs.maxBackups >= 0, "log-file-max-backups", "must not be negative")
+		v.Check(cfg.logs.maxAge >= 0, "log-file-max-age", "must not be negative")
+	}
+	v.Check(cfg.logs.buffer >= 0, "log-buffer", "must not be negative")
+	v.Check(cfg.logs.sampleTick >= 0, "log-sample-tick", "must not be negative")
+	v.Check(cfg.logs.sampleFirst >= 0, "log-sample-first", "must not be negative")
+	v.Check(cfg.logs.sampleThereafter >= 0, "log-sample-thereafter", "must not be negative")
+
+	for _, origin := range cfg.cors.trustedOrigins {
+		u, err := url.Parse(origin)
+		v.Check(err == nil && u.Scheme != "" && u.Host != "", "cors-trusted-origins", "must be absolute origins such as https://example.com")
+	}
+
+	if v.Valid() {
+		return nil
+	}
+
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := make([]string, len(keys))
+	for i, key := range keys {
+		problems[i] = key + " " + v.Errors[key]
+	}
+
+	return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+}
+
+// effectiveConfig returns every setting's current value, keyed by flag name,
+// with secrets redacted.
+func effectiveConfig(fs *flag.FlagSet) map[string]string {
+	settings := make(map[string]string)
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if settingsOnly[f.Name] {
+			return
+		}
+		settings[f.Name] = redactSetting(f.Name, f.Value.String())
+	})
+
+	return settings
+}
+
+func redactSetting(name, value string) string {
+	if !secretSettings[name] || value == "" {
+		return value
+	}
+
+	// Keep the rest of a URL-style DSN visible, since the host and database
+	// are what you usually want to check.
+	if name == "db-dsn" {
+		u, err := url.Parse(value)
+		if err == nil && u.Scheme != "" && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				return u.Redacted()
+			}
+		}
+	}
+
+	return "[REDACTED]"
+}
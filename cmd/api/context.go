@@ -8,9 +8,40 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	requestIDContextKey   = contextKey("request_id")
+	requestInfoContextKey = contextKey("request_info")
+)
+
+// requestInfo is placed in the request context by the outermost middleware
+// and filled in as the request passes through the rest of the chain, so that
+// the route, request ID and user are available for the access log and
+// metrics once the response has been written.
+type requestInfo struct {
+	route     string
+	requestID string
+	traceID   string
+	userID    int64
+}
+
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestInfo returns nil for requests that didn't pass through the
+// metrics middleware.
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if info := app.contextGetRequestInfo(r); info != nil {
+		info.userID = user.ID
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -22,3 +53,19 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	if info := app.contextGetRequestInfo(r); info != nil {
+		info.requestID = id
+	}
+
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns an empty string rather than panicking, since
+// errors can be reported before the requestID middleware has run.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
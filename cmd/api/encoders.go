@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"greenlight.bcc/internal/msgpack"
+)
+
+// errNotTabular is returned by the CSV encoder for responses that don't
+// hold a list of records.
+var errNotTabular = errors.New("response cannot be represented as CSV")
+
+// An encoder renders a response envelope in one media type. Every encoder
+// except JSON works from the envelope's JSON form, decoded into an ordered
+// tree, so field names, omitted fields and custom marshallers such as
+// data.Runtime come out the same in every format.
+type encoder struct {
+	contentType string
+	mediaTypes  []string
+	encode      func(w io.Writer, data envelope) error
+}
+
+var encoders = []encoder{
+	{
+		contentType: "application/json",
+		mediaTypes:  []string{"application/json"},
+		encode:      encodeJSON,
+	},
+	{
+		contentType: "application/xml; charset=utf-8",
+		mediaTypes:  []string{"application/xml", "text/xml"},
+		encode:      encodeXML,
+	},
+	{
+		contentType: "text/csv; charset=utf-8",
+		mediaTypes:  []string{"text/csv"},
+		encode:      encodeCSV,
+	},
+	{
+		contentType: "application/msgpack",
+		mediaTypes:  []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		encode:      encodeMsgpack,
+	},
+}
+
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// negotiateEncoder picks one of offers for the Accept header, honouring
+// q-values and preferring more specific ranges at equal q. A client that
+// lists application/problem+json at the top q gets it for errors whatever
+// order the header is in. It reports false when the client accepts none of
+// them.
+func negotiateEncoder(accept string, offers []encoder) (encoder, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		if si, sj := specificity(ranges[i].mediaType), specificity(ranges[j].mediaType); si != sj {
+			return si > sj
+		}
+		return ranges[i].mediaType == problemEncoder.contentType && ranges[j].mediaType != problemEncoder.contentType
+	})
+
+	for _, rng := range ranges {
+		for _, enc := range offers {
+			for _, mediaType := range enc.mediaTypes {
+				if mediaTypeMatches(rng.mediaType, mediaType) {
+					return enc, true
+				}
+			}
+		}
+	}
+
+	return encoder{}, false
+}
+
+func specificity(mediaType string) int {
+	switch {
+	case mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(mediaType, "/*"):
+		return 1
+	default:
+		return 2
+	}
+}
+
+func mediaTypeMatches(pattern, mediaType string) bool {
+	switch {
+	case pattern == "*/*":
+		return true
+	case strings.HasSuffix(pattern, "/*"):
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	default:
+		return pattern == mediaType
+	}
+}
+
+func encodeJSON(w io.Writer, data envelope) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(js, '\n'))
+	return err
+}
+
+func encodeXML(w io.Writer, data envelope) error {
+	tree, err := toOrderedTree(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	err = writeXMLValue(enc, "response", tree)
+	if err != nil {
+		return err
+	}
+
+	return enc.Flush()
+}
+
+// writeXMLValue renders objects as nested elements named after their keys
+// and arrays as repeated <item> elements.
+func writeXMLValue(enc *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case orderedObject:
+		for _, field := range value {
+			err = writeXMLValue(enc, field.key, field.value)
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range value {
+			err = writeXMLValue(enc, "item", item)
+			if err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		err = enc.EncodeToken(xml.CharData(scalarString(value)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// encodeCSV writes the single list of records held by the envelope, such as
+// "movies" in the list response, one row per record. Other members of the
+// envelope, like "metadata", are left out.
+func encodeCSV(w io.Writer, data envelope) error {
+	tree, err := toOrderedTree(data)
+	if err != nil {
+		return err
+	}
+
+	var records []any
+	for _, field := range tree.(orderedObject) {
+		list, ok := field.value.([]any)
+		if !ok || !allObjects(list) {
+			continue
+		}
+		if records != nil {
+			return errNotTabular
+		}
+		records = list
+	}
+	if records == nil {
+		return errNotTabular
+	}
+
+	var columns []string
+	seen := make(map[string]bool)
+	for _, record := range records {
+		for _, field := range record.(orderedObject) {
+			if !seen[field.key] {
+				seen[field.key] = true
+				columns = append(columns, field.key)
+			}
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	err = cw.Write(columns)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i], err = csvCell(record.(orderedObject).get(column))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = cw.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func allObjects(list []any) bool {
+	for _, item := range list {
+		if _, ok := item.(orderedObject); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// csvCell joins lists of scalars, such as genres, with commas and falls back
+// to compact JSON for anything more deeply nested.
+func csvCell(value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case orderedObject:
+		js, err := json.Marshal(value)
+		return string(js), err
+	case []any:
+		parts := make([]string, len(value))
+		for i, item := range value {
+			switch item.(type) {
+			case orderedObject, []any:
+				js, err := json.Marshal(value)
+				return string(js), err
+			}
+			parts[i] = scalarString(item)
+		}
+		return strings.Join(parts, ","), nil
+	default:
+		return scalarString(value), nil
+	}
+}
+
+func encodeMsgpack(w io.Writer, data envelope) error {
+	tree, err := toOrderedTree(data)
+	if err != nil {
+		return err
+	}
+
+	mw := msgpack.NewWriter(w)
+	writeMsgpackValue(mw, tree)
+	return mw.Err()
+}
+
+func writeMsgpackValue(mw *msgpack.Writer, value any) {
+	switch value := value.(type) {
+	case orderedObject:
+		mw.WriteMapHeader(len(value))
+		for _, field := range value {
+			mw.WriteString(field.key)
+			writeMsgpackValue(mw, field.value)
+		}
+	case []any:
+		mw.WriteArrayHeader(len(value))
+		for _, item := range value {
+			writeMsgpackValue(mw, item)
+		}
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			mw.WriteInt(i)
+			return
+		}
+		f, _ := value.Float64()
+		mw.WriteFloat(f)
+	case string:
+		mw.WriteString(value)
+	case bool:
+		mw.WriteBool(value)
+	default:
+		mw.WriteNil()
+	}
+}
+
+func scalarString(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		return ""
+	}
+}
+
+type orderedField struct {
+	key   string
+	value any
+}
+
+// orderedObject is a decoded JSON object that keeps its keys in the order
+// they were encoded, so struct fields keep their declared order.
+type orderedObject []orderedField
+
+func (o orderedObject) get(key string) any {
+	for _, field := range o {
+		if field.key == key {
+			return field.value
+		}
+	}
+	return nil
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// toOrderedTree round-trips data through encoding/json. Map keys, which
+// encoding/json sorts, come back sorted; struct fields keep their order.
+func toOrderedTree(data any) (any, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	return decodeOrdered(dec)
+}
+
+func decodeOrdered(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+
+	switch delim {
+	case '{':
+		obj := orderedObject{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+
+			obj = append(obj, orderedField{key: keyTok.(string), value: value})
+		}
+		_, err = dec.Token()
+		return obj, err
+	default:
+		list := []any{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err = dec.Token()
+		return list, err
+	}
+}
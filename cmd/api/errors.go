@@ -1,21 +1,58 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+
+	"greenlight.bcc/internal/trace"
+	"greenlight.bcc/internal/validator"
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	properties := map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
-	})
+	}
+
+	if id := app.contextGetRequestID(r); id != "" {
+		properties["request_id"] = id
+	}
+
+	if sc := trace.FromContext(r.Context()).SpanContext(); sc.IsValid() {
+		properties["trace_id"] = sc.TraceID.String()
+		properties["span_id"] = sc.SpanID.String()
+	}
+
+	app.logger.PrintError(err, properties)
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
+// errorResponse sends message, either a string or a validator holding the
+// failed checks, with a stable machine-readable code. Clients that ask for
+// application/problem+json get an RFC 7807 problem document; everyone else
+// gets the original {"error": message} envelope in the negotiated format,
+// falling back to JSON rather than masking the error with a 406.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message any) {
+	enc, ok := negotiateEncoder(r.Header.Get("Accept"), errorEncoders)
+	if !ok {
+		enc = encoders[0]
+	}
+
 	env := envelope{"error": message}
+	if v, ok := message.(*validator.Validator); ok {
+		env["error"] = v.Errors
+	}
+	if id := app.contextGetRequestID(r); id != "" {
+		env["request_id"] = id
+	}
+	if enc.contentType == problemEncoder.contentType {
+		env = app.problem(r, status, code, message)
+	}
 
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeEncoded(w, enc, status, env, nil)
+	if errors.Is(err, errNotTabular) {
+		err = app.writeEncoded(w, encoders[0], status, env, nil)
+	}
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -25,59 +62,107 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, "server_error", message)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, "not_found", message)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, "method_not_allowed", message)
+}
+
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource can't be represented in any of the formats accepted by the request"
+	app.errorResponse(w, r, http.StatusNotAcceptable, "not_acceptable", message)
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, "bad_request", err.Error())
 }
 
-func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, v *validator.Validator) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, "failed_validation", v)
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, "edit_conflict", message)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, "rate_limited", message)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid_credentials", message)
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "invalid_token", message)
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, "authentication_required", message)
 }
 
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, "inactive_account", message)
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, "not_permitted", message)
+}
+
+func (app *application) proposalAlreadyReviewedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the proposal has already been reviewed"
+	app.errorResponse(w, r, http.StatusConflict, "proposal_already_reviewed", message)
+}
+
+func (app *application) selfReviewResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you can't review your own proposal"
+	app.errorResponse(w, r, http.StatusForbidden, "self_review", message)
+}
+
+func (app *application) proposalMovieDeletedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the movie this proposal edits has been deleted"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, "movie_deleted", message)
+}
+
+func (app *application) invalidPatchResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, "invalid_patch", err.Error())
+}
+
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, "patch_test_failed", err.Error())
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has changed since you last fetched it, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, "precondition_failed", message)
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must include an If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, "precondition_required", message)
+}
+
+func (app *application) idempotencyKeyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with this idempotency key is still being processed, please try again later"
+	app.errorResponse(w, r, http.StatusConflict, "idempotency_key_in_progress", message)
+}
+
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this idempotency key has already been used for a different request"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, "idempotency_key_reused", message)
 }
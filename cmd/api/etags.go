@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"greenlight.bcc/internal/data"
+)
+
+// movieETag derives a strong entity tag from the movie's id and version.
+// Every successful update bumps the version, so the tag changes exactly
+// when the stored movie does.
+func movieETag(movie *data.Movie) string {
+	return movieETagPrefix(movie) + `"`
+}
+
+// movieETagPrefix is the opening quote, id and version that every tag for
+// the movie starts with.
+func movieETagPrefix(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d`, movie.ID, movie.Version)
+}
+
+// movieVariantETag is movieETag for a representation of the movie other
+// than the full JSON one, such as one trimmed with fields= or encoded in
+// another format. The variant is hashed into the tag, so that caches never
+// confuse two representations, while the id and version still lead it, so
+// that it can be sent back in If-Match.
+func movieVariantETag(movie *data.Movie, contentType string, fields []string) string {
+	if contentType == encoders[0].contentType && len(fields) == 0 {
+		return movieETag(movie)
+	}
+
+	sum := sha256.Sum256([]byte(contentType + "\n" + strings.Join(fields, ",")))
+	return movieETagPrefix(movie) + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// movieETagMatches reports whether etag is the movieETag of movie or one of
+// its variants made by movieVariantETag. Weak tags never match.
+func movieETagMatches(etag string, movie *data.Movie) bool {
+	prefix := movieETagPrefix(movie)
+	return etag == prefix+`"` || strings.HasPrefix(etag, prefix+"-")
+}
+
+// weakETag hashes the JSON form of a response envelope. It's used for list
+// responses, which have no version of their own to derive a tag from.
+func weakETag(data envelope) (string, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(js)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches reports whether etag appears in the comma-separated list held
+// by an If-None-Match header, using weak comparison. "*" matches any
+// current representation.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notModified sends a 304 Not Modified if the request's If-None-Match
+// header matches etag, and reports whether it did.
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagMatches(header, etag) {
+		return false
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// requireIfMatch sends a 428 Precondition Required when the server is
+// configured to insist on If-Match for unsafe requests and the request has
+// none. It reports whether the request may go ahead.
+func (app *application) requireIfMatch(w http.ResponseWriter, r *http.Request) bool {
+	if app.config.requireIfMatch && r.Header.Get("If-Match") == "" {
+		app.preconditionRequiredResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// checkIfMatch sends a 412 Precondition Failed if the request carries an
+// If-Match header that matches none of the movie's tags, in any variant.
+// It reports whether the request may go ahead.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || movieETagMatches(candidate, movie) {
+			return true
+		}
+	}
+
+	app.preconditionFailedResponse(w, r)
+	return false
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/validator"
+)
+
+// exportFlushEvery is the number of movies written between flushes, so
+// clients see the export make progress without a flush per row.
+const exportFlushEvery = 100
+
+// exportWriteTimeout is how long the export may take to write the next
+// exportFlushEvery movies. The deadline is pushed back after every flush, so
+// a large export isn't cut off by the server's WriteTimeout while a stalled
+// client still is.
+const exportWriteTimeout = 30 * time.Second
+
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieFilters
+		Format string
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.MovieFilters = app.readMovieFilters(qs, v)
+	input.Format = app.readString(qs, "format", "ndjson")
+
+	data.ValidateMovieFilters(v, input.MovieFilters)
+	v.CheckCode(validator.PermittedValue(input.Format, "ndjson", "csv"), "format", "not_permitted", "must be ndjson or csv")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	var (
+		started   bool
+		written   int
+		csvWriter *csv.Writer
+		enc       = json.NewEncoder(w)
+	)
+
+	rc := http.NewResponseController(w)
+	extendDeadline := func() {
+		// Writers that don't support deadlines have no timeout to extend.
+		_ = rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	}
+	flush := func() {
+		if csvWriter != nil {
+			csvWriter.Flush()
+		}
+		_ = rc.Flush()
+		extendDeadline()
+	}
+
+	// The response is only started once the first movie arrives, so a query
+	// that fails up front can still be reported with a normal error response.
+	start := func() error {
+		started = true
+		extendDeadline()
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "movies."+input.Format))
+
+		if input.Format == "csv" {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			csvWriter = csv.NewWriter(w)
+			return csvWriter.Write([]string{"id", "title", "year", "runtime", "genres", "version"})
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	writeRow := func(movie *data.Movie) error {
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch input.Format {
+		case "csv":
+			err = csvWriter.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.Itoa(int(movie.Year)),
+				fmt.Sprintf("%d mins", movie.Runtime),
+				strings.Join(movie.Genres, ","),
+				strconv.Itoa(int(movie.Version)),
+			})
+		default:
+			err = enc.Encode(movie)
+		}
+		if err != nil {
+			return err
+		}
+
+		written++
+		if written%exportFlushEvery == 0 {
+			flush()
+		}
+		return nil
+	}
+
+	err := app.modelsFor(r).Movies.Export(r.Context(), input.MovieFilters, writeRow)
+	if err == nil && !started {
+		err = start()
+	}
+
+	switch {
+	case err == nil:
+		flush()
+	case r.Context().Err() != nil:
+		app.logger.PrintInfo("movie export cancelled by client", map[string]string{
+			"request_url": r.URL.String(),
+			"written":     strconv.Itoa(written),
+		})
+	case !started:
+		app.serverErrorResponse(w, r, err)
+	default:
+		// The response is already under way, so all that can be done is to
+		// log the failure and cut the stream short.
+		app.logError(r, err)
+	}
+}
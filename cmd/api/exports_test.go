@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"greenlight.bcc/internal/assert"
+)
+
+func TestExportMovies(t *testing.T) {
+	app := newTestApplication(t, false)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		wantCode        int
+		wantContentType string
+		wantBody        string
+		wantLines       int
+	}{
+		{
+			name:            "default NDJSON",
+			urlPath:         "/v1/movies/export",
+			wantCode:        http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody:        `{"id":2,"title":"Test Mock"`,
+			wantLines:       2,
+		},
+		{
+			name:            "CSV with filters",
+			urlPath:         "/v1/movies/export?format=csv&title=test&genres=comedy",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/csv; charset=utf-8",
+			wantBody:        "1,Test Mock,2023,105 mins,\"comedy,drama\",1",
+			wantLines:       3,
+		},
+		{
+			name:     "unknown format",
+			urlPath:  "/v1/movies/export?format=xml",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "error while exporting",
+			urlPath:  "/v1/movies/export?title=error",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantContentType == "" {
+				return
+			}
+
+			assert.Equal(t, headers.Get("Content-Type"), tt.wantContentType)
+			assert.StringContains(t, body, tt.wantBody)
+			assert.Equal(t, len(strings.Split(strings.TrimSpace(body), "\n")), tt.wantLines)
+		})
+	}
+}
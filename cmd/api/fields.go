@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/validator"
+)
+
+var (
+	// movieFieldSafelist holds the movie fields that can be picked with
+	// fields=. The highlight is only present when searching by title.
+	movieFieldSafelist = []string{"id", "title", "year", "runtime", "genres", "version", "highlight"}
+	// movieIncludeSafelist holds the related data that can be embedded in
+	// movies with include=.
+	movieIncludeSafelist = []string{"proposals"}
+)
+
+func (app *application) readIncludes(qs url.Values, v *validator.Validator) []string {
+	include := app.readCSV(qs, "include", []string{})
+
+	for _, name := range include {
+		v.CheckCode(validator.PermittedValue(name, movieIncludeSafelist...), "include", "not_permitted", "invalid include value")
+	}
+	v.CheckCode(validator.Unique(include), "include", "duplicate", "must not contain duplicate values")
+
+	return include
+}
+
+// authorizeIncludes checks the user may see the related data they asked
+// for. Proposals are only visible to reviewers, as they are through
+// /v1/proposals. It reports whether the request may go ahead.
+func (app *application) authorizeIncludes(w http.ResponseWriter, r *http.Request, include []string) bool {
+	if !validator.PermittedValue("proposals", include...) {
+		return true
+	}
+
+	permissions, err := app.modelsFor(r).Permissions.GetAllForUser(app.contextGetUser(r).ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !permissions.Include("movies:approve") {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// renderMovies trims movies to fields and embeds the related data named in
+// include. With neither set the movies are returned untouched.
+func (app *application) renderMovies(r *http.Request, movies []*data.Movie, fields, include []string) ([]any, error) {
+	rendered := make([]any, len(movies))
+	for i, movie := range movies {
+		rendered[i] = movie
+	}
+
+	if len(fields) == 0 && len(include) == 0 {
+		return rendered, nil
+	}
+
+	var proposals map[int64][]*data.Proposal
+	if validator.PermittedValue("proposals", include...) {
+		ids := make([]int64, len(movies))
+		for i, movie := range movies {
+			ids[i] = movie.ID
+		}
+
+		var err error
+		proposals, err = app.modelsFor(r).Proposals.GetPendingForMovies(ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, movie := range movies {
+		tree, err := toOrderedTree(movie)
+		if err != nil {
+			return nil, err
+		}
+
+		obj := tree.(orderedObject)
+		if len(fields) > 0 {
+			var kept orderedObject
+			for _, field := range obj {
+				if validator.PermittedValue(field.key, fields...) {
+					kept = append(kept, field)
+				}
+			}
+			obj = kept
+		}
+
+		if proposals != nil {
+			embedded := proposals[movie.ID]
+			if embedded == nil {
+				embedded = []*data.Proposal{}
+			}
+			obj = append(obj, orderedField{key: "proposals", value: embedded})
+		}
+
+		rendered[i] = obj
+	}
+
+	return rendered, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"greenlight.bcc/internal/assert"
+)
+
+func TestSparseFieldsets(t *testing.T) {
+	app := newTestApplication(t, false)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		urlPath     string
+		token       string
+		wantCode    int
+		wantBody    string
+		notWantBody string
+	}{
+		{
+			name:     "list without fields",
+			urlPath:  "/v1/movies",
+			wantCode: http.StatusOK,
+			wantBody: `{"id":1,"title":"Test Mock","year":2023,"runtime":"105 mins","genres":["comedy","drama"],"version":1}`,
+		},
+		{
+			name:        "list with fields",
+			urlPath:     "/v1/movies?fields=id,title",
+			wantCode:    http.StatusOK,
+			wantBody:    `"movies":[{"id":1,"title":"Test Mock"},{"id":2,"title":"Test Mock"}]`,
+			notWantBody: "runtime",
+		},
+		{
+			name:        "list with fields keeps formatting",
+			urlPath:     "/v1/movies?fields=runtime",
+			wantCode:    http.StatusOK,
+			wantBody:    `{"runtime":"105 mins"}`,
+			notWantBody: `"id"`,
+		},
+		{
+			name:     "list with unknown field",
+			urlPath:  "/v1/movies?fields=id,created_at",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "invalid fields value",
+		},
+		{
+			name:     "list with duplicate fields",
+			urlPath:  "/v1/movies?fields=id,id",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "list including proposals",
+			urlPath:  "/v1/movies?fields=id&include=proposals",
+			wantCode: http.StatusOK,
+			wantBody: `{"id":2,"proposals":[]}`,
+		},
+		{
+			name:     "list with unknown include",
+			urlPath:  "/v1/movies?include=reviews",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "invalid include value",
+		},
+		{
+			name:     "show with fields",
+			urlPath:  "/v1/movies/1?fields=title,version",
+			wantCode: http.StatusOK,
+			wantBody: `{"movie":{"title":"Test Mock","version":1}}`,
+		},
+		{
+			name:     "show including proposals",
+			urlPath:  "/v1/movies/1?include=proposals",
+			wantCode: http.StatusOK,
+			wantBody: `"proposals":[{"id":2,`,
+		},
+		{
+			name:     "show with unknown field",
+			urlPath:  "/v1/movies/1?fields=director",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "include without permission",
+			urlPath:  "/v1/movies?include=proposals",
+			token:    "rrrrrrrrrrrrrrrrrrrrrrrrrr",
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tt.token
+			if token == "" {
+				token = "abcdefghijklmnopqrstuvwxyz"
+			}
+
+			headers := map[string]string{
+				"Authorization": "Bearer " + token,
+			}
+
+			code, _, body := ts.getCustomHeaders(t, tt.urlPath, headers)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+			if tt.notWantBody != "" {
+				assert.Equal(t, strings.Contains(body, tt.notWantBody), false)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"greenlight.bcc/internal/migrate"
+	"greenlight.bcc/migrations"
+)
+
+// healthCheck is one dependency that /readyz checks. A failing check that
+// isn't critical is reported but doesn't make the server not ready; that is
+// for dependencies, such as the mail server, that only some requests need.
+type healthCheck struct {
+	name     string
+	critical bool
+	check    func(ctx context.Context) error
+}
+
+// health tracks whether the server should receive traffic.
+type health struct {
+	checks       []healthCheck
+	shuttingDown atomic.Bool
+
+	// mu guards the results of the last run, which are reused for a short
+	// while so that probes can't be used to hammer the dependencies.
+	mu      sync.Mutex
+	checked time.Time
+	results map[string]checkResult
+	ready   bool
+}
+
+// checkResult is how one check is reported on the admin listener's
+// /debug/readyz. The public /readyz only says whether each check passed.
+type checkResult struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// run performs every check concurrently, each with its own timeout, and
+// reports whether all the critical ones passed.
+func (h *health) run(ctx context.Context, timeout time.Duration) (map[string]checkResult, bool) {
+	results := make(map[string]checkResult, len(h.checks))
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	ready := true
+	for _, hc := range h.checks {
+		wg.Add(1)
+		go func(hc healthCheck) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := hc.check(ctx)
+
+			result := checkResult{
+				Status:    "pass",
+				Critical:  hc.critical,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[hc.name] = result
+			if err != nil && hc.critical {
+				ready = false
+			}
+		}(hc)
+	}
+
+	wg.Wait()
+
+	return results, ready
+}
+
+// cached returns the results of the last run if it finished less than ttl
+// ago, and otherwise runs the checks again. Callers that arrive during a
+// run wait for it rather than starting their own. The checks don't use the
+// caller's context, since their results are shared.
+func (h *health) cached(ttl, timeout time.Duration) (map[string]checkResult, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.results == nil || time.Since(h.checked) >= ttl {
+		h.results, h.ready = h.run(context.Background(), timeout)
+		h.checked = time.Now()
+	}
+
+	return h.results, h.ready
+}
+
+// newHealth builds the checks that /readyz runs against the server's
+// dependencies.
+func (app *application) newHealth(db *sql.DB) *health {
+	smtpAddr := net.JoinHostPort(app.config.smtp.host, strconv.Itoa(app.config.smtp.port))
+
+	return &health{
+		checks: []healthCheck{
+			{
+				name:     "database",
+				critical: true,
+				check:    db.PingContext,
+			},
+			{
+				name:     "migrations",
+				critical: true,
+				check: func(ctx context.Context) error {
+					m, err := migrate.New(db, migrations.FS)
+					if err != nil {
+						return err
+					}
+
+					status, err := m.Peek(ctx)
+					if err != nil {
+						return err
+					}
+					return migrationError(status)
+				},
+			},
+			{
+				name: "smtp",
+				check: func(ctx context.Context) error {
+					var d net.Dialer
+					conn, err := d.DialContext(ctx, "tcp", smtpAddr)
+					if err != nil {
+						return err
+					}
+					return conn.Close()
+				},
+			},
+			{
+				name:     "background",
+				critical: true,
+				check:    app.checkBackground,
+			},
+		},
+	}
+}
+
+// checkBackground fails when so many background tasks are running that
+// new work is likely to wait a long time, such as when the mail server is
+// slow to respond.
+func (app *application) checkBackground(ctx context.Context) error {
+	running := int(app.telemetry.backgroundRunning.Value())
+	limit := app.config.health.maxBackgroundTasks
+	if limit > 0 && running > limit {
+		return fmt.Errorf("%d background tasks running, more than the limit of %d", running, limit)
+	}
+	return nil
+}
+
+// livezHandler reports that the process is up and able to serve requests.
+// It doesn't check dependencies, because restarting the server wouldn't fix
+// them.
+func (app *application) livezHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readyzHandler reports whether the server should receive traffic, and
+// whether each dependency check passed. It responds 503 Service Unavailable
+// when a critical check fails or the server is shutting down. The endpoint
+// is public, so it leaves out latencies and error messages; those are on
+// the admin listener's /debug/readyz.
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeReadiness(w, r, false)
+}
+
+// debugReadyzHandler is readyzHandler with the latency and error of each
+// check.
+func (app *application) debugReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeReadiness(w, r, true)
+}
+
+func (app *application) writeReadiness(w http.ResponseWriter, r *http.Request, detailed bool) {
+	if app.health.shuttingDown.Load() {
+		err := app.writeResponse(w, r, http.StatusServiceUnavailable, envelope{"status": "shutting down"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	results, ready := app.health.cached(app.config.health.cacheTTL, app.config.health.timeout)
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+
+	var checks any = results
+	if !detailed {
+		statuses := make(map[string]string, len(results))
+		for name, result := range results {
+			statuses[name] = result.Status
+		}
+		checks = statuses
+	}
+
+	err := app.writeResponse(w, r, code, envelope{"status": status, "checks": checks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// isProbe reports whether r is a liveness or readiness probe, which must not
+// be rate limited.
+func isProbe(r *http.Request) bool {
+	return r.URL.Path == "/livez" || r.URL.Path == "/readyz"
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"greenlight.bcc/internal/assert"
+)
+
+func TestLivez(t *testing.T) {
+	app := newTestApplication(t, false)
+	app.health.checks = []healthCheck{
+		{name: "database", critical: true, check: func(ctx context.Context) error { return errors.New("connection refused") }},
+	}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/livez")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `"status":"alive"`)
+}
+
+func TestReadyz(t *testing.T) {
+	pass := func(ctx context.Context) error { return nil }
+	fail := func(ctx context.Context) error { return errors.New("connection refused") }
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	tests := []struct {
+		name         string
+		checks       []healthCheck
+		shuttingDown bool
+		wantCode     int
+		wantStatus   string
+		wantChecks   map[string]string
+	}{
+		{
+			name: "all passing",
+			checks: []healthCheck{
+				{name: "database", critical: true, check: pass},
+				{name: "smtp", check: pass},
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "ready",
+			wantChecks: map[string]string{"database": "pass", "smtp": "pass"},
+		},
+		{
+			name: "critical failing",
+			checks: []healthCheck{
+				{name: "database", critical: true, check: fail},
+				{name: "smtp", check: pass},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "not ready",
+			wantChecks: map[string]string{"database": "fail", "smtp": "pass"},
+		},
+		{
+			name: "non-critical failing",
+			checks: []healthCheck{
+				{name: "database", critical: true, check: pass},
+				{name: "smtp", check: fail},
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "ready",
+			wantChecks: map[string]string{"database": "pass", "smtp": "fail"},
+		},
+		{
+			name: "timing out",
+			checks: []healthCheck{
+				{name: "database", critical: true, check: hang},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "not ready",
+			wantChecks: map[string]string{"database": "fail"},
+		},
+		{
+			name: "shutting down",
+			checks: []healthCheck{
+				{name: "database", critical: true, check: pass},
+			},
+			shuttingDown: true,
+			wantCode:     http.StatusServiceUnavailable,
+			wantStatus:   "shutting down",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, false)
+			app.config.health.timeout = 50 * time.Millisecond
+			app.health.checks = tt.checks
+			app.health.shuttingDown.Store(tt.shuttingDown)
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, _, body := ts.get(t, "/readyz")
+			assert.Equal(t, code, tt.wantCode)
+
+			var resp struct {
+				Status string            `json:"status"`
+				Checks map[string]string `json:"checks"`
+			}
+			assert.NilError(t, json.Unmarshal([]byte(body), &resp))
+			assert.Equal(t, resp.Status, tt.wantStatus)
+			assert.Equal(t, len(resp.Checks), len(tt.wantChecks))
+			assert.Equal(t, strings.Contains(body, "connection refused"), false)
+
+			for name, want := range tt.wantChecks {
+				assert.Equal(t, resp.Checks[name], want)
+			}
+
+			admin := newTestServer(t, app.adminRoutes())
+			defer admin.Close()
+
+			code, _, body = admin.get(t, "/debug/readyz")
+			assert.Equal(t, code, tt.wantCode)
+
+			var detail struct {
+				Status string                 `json:"status"`
+				Checks map[string]checkResult `json:"checks"`
+			}
+			assert.NilError(t, json.Unmarshal([]byte(body), &detail))
+			assert.Equal(t, detail.Status, tt.wantStatus)
+			assert.Equal(t, len(detail.Checks), len(tt.wantChecks))
+
+			for name, want := range tt.wantChecks {
+				result := detail.Checks[name]
+				assert.Equal(t, result.Status, want)
+				assert.Equal(t, result.Error != "", want == "fail")
+				assert.Equal(t, result.LatencyMS >= 0, true)
+			}
+		})
+	}
+}
+
+func TestReadyzCached(t *testing.T) {
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		requests int
+		want     int32
+	}{
+		{"within ttl", time.Minute, 5, 1},
+		{"no caching", 0, 5, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+
+			app := newTestApplication(t, false)
+			app.config.health.timeout = 50 * time.Millisecond
+			app.config.health.cacheTTL = tt.ttl
+			app.health.checks = []healthCheck{
+				{name: "database", critical: true, check: func(ctx context.Context) error {
+					calls.Add(1)
+					return nil
+				}},
+			}
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			for i := 0; i < tt.requests; i++ {
+				code, _, _ := ts.get(t, "/readyz")
+				assert.Equal(t, code, http.StatusOK)
+			}
+
+			assert.Equal(t, calls.Load(), tt.want)
+		})
+	}
+}
+
+func TestProbesNotRateLimited(t *testing.T) {
+	app := newTestApplication(t, true)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for i := 0; i < 10; i++ {
+		code, _, _ := ts.get(t, "/readyz")
+		assert.Equal(t, code, http.StatusOK)
+
+		code, _, _ = ts.get(t, "/livez")
+		assert.Equal(t, code, http.StatusOK)
+	}
+
+	// Other routes are still limited once the burst is used up.
+	var limited bool
+	for i := 0; i < 10; i++ {
+		code, _, _ := ts.get(t, "/v1/healthcheck")
+		if code == http.StatusTooManyRequests {
+			limited = true
+		}
+	}
+	assert.Equal(t, limited, true)
+}
+
+func TestCheckBackground(t *testing.T) {
+	app := newTestApplication(t, false)
+	app.config.health.maxBackgroundTasks = 1
+
+	release := make(chan struct{})
+	defer func() {
+		close(release)
+		app.wg.Wait()
+	}()
+
+	assert.NilError(t, app.checkBackground(context.Background()))
+
+	app.background(func() { <-release })
+	assert.NilError(t, app.checkBackground(context.Background()))
+
+	app.background(func() { <-release })
+	err := app.checkBackground(context.Background())
+	assert.Equal(t, err != nil, true)
+	assert.StringContains(t, err.Error(), "2 background tasks running")
+}
+
+func TestHealthcheckWithoutUser(t *testing.T) {
+	app := newTestApplication(t, false)
+
+	rr := httptest.NewRecorder()
+	app.healthcheckHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/healthcheck", nil))
+
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.StringContains(t, rr.Body.String(), `"status":"available"`)
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"greenlight.bcc/internal/data"
 	"greenlight.bcc/internal/validator"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type envelope map[string]any
@@ -25,23 +30,41 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+// writeResponse encodes data in the format negotiated from the request's
+// Accept header and replies 406 Not Acceptable when none of the encoders
+// can satisfy it.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	enc, ok := negotiateEncoder(r.Header.Get("Accept"), encoders)
+	if !ok {
+		app.notAcceptableResponse(w, r)
+		return nil
+	}
+
+	err := app.writeEncoded(w, enc, status, data, headers)
+	if errors.Is(err, errNotTabular) {
+		app.notAcceptableResponse(w, r)
+		return nil
+	}
 
-	js, err := json.Marshal(data)
+	return err
+}
+
+func (app *application) writeEncoded(w http.ResponseWriter, enc encoder, status int, data envelope, headers http.Header) error {
+	var buf bytes.Buffer
+	err := enc.encode(&buf, data)
 	if err != nil {
 		return err
 	}
 
-	js = append(js, '\n')
-
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", enc.contentType)
 	w.WriteHeader(status)
 
-	w.Write(js)
+	w.Write(buf.Bytes())
 
 	return nil
 }
@@ -120,19 +143,104 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	}
 	i, err := strconv.Atoi(s)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddErrorCode(key, "invalid_type", "must be an integer value")
 		return defaultValue
 	}
 
 	return i
 }
 
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddErrorCode(key, "invalid_type", "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+func (app *application) readIntRange(qs url.Values, key string, v *validator.Validator) data.Range[int] {
+	return readRange(qs, key, strconv.Atoi, "invalid_type", "must be an integer value", v)
+}
+
+func (app *application) readTimeRange(qs url.Values, key string, v *validator.Validator) data.Range[time.Time] {
+	parse := func(s string) (time.Time, error) {
+		return time.Parse(time.RFC3339, s)
+	}
+	return readRange(qs, key, parse, "invalid_format", "must be an RFC 3339 timestamp", v)
+}
+
+// readRange reads the key_gt, key_gte, key_lt and key_lte bounds of a range
+// filter, leaving absent bounds nil.
+func readRange[T int | time.Time](qs url.Values, key string, parse func(string) (T, error), code, message string, v *validator.Validator) data.Range[T] {
+	var r data.Range[T]
+
+	bounds := map[string]**T{
+		"_gt":  &r.GT,
+		"_gte": &r.GTE,
+		"_lt":  &r.LT,
+		"_lte": &r.LTE,
+	}
+
+	for suffix, bound := range bounds {
+		s := qs.Get(key + suffix)
+		if s == "" {
+			continue
+		}
+
+		value, err := parse(s)
+		if err != nil {
+			v.AddErrorCode(key+suffix, code, message)
+			continue
+		}
+		*bound = &value
+	}
+
+	return r
+}
+
+// validRequestID reports whether an X-Request-ID sent by a client or proxy
+// can be reused. Anything long or containing characters that could corrupt a
+// log line is replaced with a new ID.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:+/=", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
+	app.telemetry.backgroundStarted.Inc()
+	app.telemetry.backgroundRunning.Inc()
 	go func() {
 		defer app.wg.Done()
+		defer app.telemetry.backgroundRunning.Dec()
 		defer func() {
 			if err := recover(); err != nil {
+				app.telemetry.backgroundPanics.Inc()
 				app.logger.PrintError(fmt.Errorf("%s", err), nil)
 			}
 		}()
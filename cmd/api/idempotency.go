@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/validator"
+)
+
+// idempotencyPurgeInterval is how often expired idempotency keys are
+// deleted.
+const idempotencyPurgeInterval = time.Hour
+
+// replayedHeaders are the response headers stored with an idempotency key
+// and sent again when the response is replayed.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// idempotent lets clients safely retry next by sending an Idempotency-Key
+// header. The first response for each user and key is stored and replayed
+// for retries with the same method, path and body; reusing a key for a
+// different request is refused, except for anonymous requests, whose keys
+// only ever match an identical request. Server errors aren't stored, so a request
+// that failed that way can be retried for real. A replay is sent in the
+// format of the stored response, or refused with 406 Not Acceptable if the
+// retry's Accept header rules that format out.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Idempotency-Key")
+		if header == "" {
+			next(w, r)
+			return
+		}
+
+		v := validator.New()
+		if data.ValidateIdempotencyKey(v, header); !v.Valid() {
+			app.failedValidationResponse(w, r, v)
+			return
+		}
+
+		maxBytes := 1_048_576
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			}
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := sha256.New()
+		fmt.Fprintf(fingerprint, "%s %s\n", r.Method, r.URL.Path)
+		fingerprint.Write(body)
+
+		user := app.contextGetUser(r)
+		key := &data.IdempotencyKey{
+			UserID:      user.ID,
+			Key:         header,
+			Fingerprint: fingerprint.Sum(nil),
+			Expiry:      time.Now().Add(app.config.idempotency.ttl),
+		}
+
+		// Anonymous clients all share user ID 0 and can't be told apart, so
+		// their keys are scoped to the request too. Otherwise one client
+		// choosing the same key as another would be refused, or sent the
+		// other's response.
+		if user.IsAnonymous() {
+			key.Key = header + ":" + hex.EncodeToString(key.Fingerprint)
+		}
+
+		existing, err := app.modelsFor(r).IdempotencyKeys.Reserve(key)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.idempotencyKeyInProgressResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case !bytes.Equal(existing.Fingerprint, key.Fingerprint):
+				app.idempotencyKeyReusedResponse(w, r)
+			case existing.Status == 0:
+				app.idempotencyKeyInProgressResponse(w, r)
+			case !acceptsStored(r.Header.Get("Accept"), existing.Header):
+				app.notAcceptableResponse(w, r)
+			default:
+				for name, values := range existing.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		// Release the key if the handler panics, so the client isn't locked
+		// out of retrying until it expires.
+		defer func() {
+			if err := recover(); err != nil {
+				app.modelsFor(r).IdempotencyKeys.Delete(key.UserID, key.Key)
+				panic(err)
+			}
+		}()
+
+		var buf bytes.Buffer
+		status := http.StatusOK
+
+		rw := httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					status = code
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					buf.Write(b)
+					return next(b)
+				}
+			},
+		})
+
+		next(rw, r)
+
+		if status >= http.StatusInternalServerError {
+			err = app.modelsFor(r).IdempotencyKeys.Delete(key.UserID, key.Key)
+			if err != nil {
+				app.logError(r, err)
+			}
+			return
+		}
+
+		key.Status = status
+		key.Body = buf.Bytes()
+		key.Header = make(map[string][]string)
+		for _, name := range replayedHeaders {
+			if values := w.Header().Values(name); len(values) > 0 {
+				key.Header[name] = values
+			}
+		}
+
+		err = app.modelsFor(r).IdempotencyKeys.Complete(key)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+}
+
+// acceptsStored reports whether a request's Accept header allows the
+// Content-Type of a stored response. A response with no body has none, and
+// is always acceptable.
+func acceptsStored(accept string, header map[string][]string) bool {
+	values := header["Content-Type"]
+	if len(values) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(values[0])
+	if err != nil {
+		return true
+	}
+
+	_, ok := negotiateEncoder(accept, []encoder{{mediaTypes: []string{mediaType}}})
+	return ok
+}
+
+// purgeIdempotencyKeys deletes expired idempotency keys every
+// idempotencyPurgeInterval until ctx is cancelled, so that keys which are
+// never reused don't pile up in the database.
+func (app *application) purgeIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.purgeExpiredIdempotencyKeys()
+		}
+	}
+}
+
+func (app *application) purgeExpiredIdempotencyKeys() {
+	deleted, err := app.models.IdempotencyKeys.DeleteExpired()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	app.logger.PrintInfo("purged expired idempotency keys", map[string]string{
+		"deleted": strconv.FormatInt(deleted, 10),
+	})
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/validator"
+)
+
+const (
+	importBatchSize   = 500
+	importMaxBytes    = 32 << 20
+	importJobTTL      = time.Hour
+	importReadTimeout = 10 * time.Minute
+)
+
+const (
+	importRunning   = "running"
+	importCompleted = "completed"
+	importFailed    = "failed"
+)
+
+type importRowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+type importJob struct {
+	ID         int64            `json:"id"`
+	UserID     int64            `json:"-"`
+	Format     string           `json:"format"`
+	DryRun     bool             `json:"dry_run"`
+	Status     string           `json:"status"`
+	Processed  int              `json:"processed"`
+	Valid      int              `json:"valid"`
+	Inserted   int              `json:"inserted"`
+	Failed     int              `json:"failed"`
+	Errors     []importRowError `json:"errors"`
+	CreatedAt  time.Time        `json:"created_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+}
+
+// importRegistry holds the state of background import jobs so clients can
+// poll their progress. Finished jobs are forgotten after importJobTTL.
+type importRegistry struct {
+	mu     sync.Mutex
+	nextID int64
+	jobs   map[int64]*importJob
+}
+
+func newImportRegistry() *importRegistry {
+	return &importRegistry{jobs: make(map[int64]*importJob)}
+}
+
+func (reg *importRegistry) add(job *importJob) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for id, j := range reg.jobs {
+		if j.FinishedAt != nil && time.Since(*j.FinishedAt) > importJobTTL {
+			delete(reg.jobs, id)
+		}
+	}
+
+	reg.nextID++
+	job.ID = reg.nextID
+	reg.jobs[job.ID] = job
+}
+
+// get returns a copy of the job so that callers can encode it while the
+// background goroutine keeps updating the original.
+func (reg *importRegistry) get(id int64) (importJob, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	job, ok := reg.jobs[id]
+	if !ok {
+		return importJob{}, false
+	}
+
+	cp := *job
+	cp.Errors = append([]importRowError{}, job.Errors...)
+	return cp, true
+}
+
+func (reg *importRegistry) update(job *importJob, fn func(job *importJob)) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	fn(job)
+}
+
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	format := app.readString(qs, "format", importFormat(r.Header.Get("Content-Type")))
+	dryRun := app.readBool(qs, "dry_run", false, v)
+
+	v.CheckCode(validator.PermittedValue(format, "csv", "ndjson"), "format", "not_permitted", "must be csv or ndjson")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	// A large upload can take longer than the server's read timeout, which
+	// is meant for ordinary requests.
+	err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(importReadTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+	body := bufio.NewReader(r.Body)
+
+	empty, err := bodyIsBlank(body)
+	if err != nil {
+		app.badRequestResponse(w, r, importBodyError(err))
+		return
+	}
+	if empty {
+		app.badRequestResponse(w, r, errors.New("body must not be empty"))
+		return
+	}
+
+	// The body can't be read once the handler has returned, so it is spooled
+	// to a temporary file and the job parses it from there. That way the
+	// response doesn't wait for the import, and the upload isn't held in
+	// memory.
+	spool, err := os.CreateTemp("", "greenlight-import-*")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	discard := func() {
+		spool.Close()
+		os.Remove(spool.Name())
+	}
+
+	_, err = io.Copy(spool, body)
+	if err != nil {
+		discard()
+		app.badRequestResponse(w, r, importBodyError(err))
+		return
+	}
+
+	_, err = spool.Seek(0, io.SeekStart)
+	if err != nil {
+		discard()
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	job := &importJob{
+		UserID:    app.contextGetUser(r).ID,
+		Format:    format,
+		DryRun:    dryRun,
+		Status:    importRunning,
+		Errors:    []importRowError{},
+		CreatedAt: time.Now(),
+	}
+	app.imports.add(job)
+
+	app.background(func() {
+		defer discard()
+		app.runImport(job, spool)
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/imports/%d", job.ID))
+
+	snapshot, _ := app.imports.get(job.ID)
+
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"import": snapshot}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showImportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	job, ok := app.imports.get(id)
+	if !ok || job.UserID != app.contextGetUser(r).ID {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"import": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// bodyIsBlank reports whether r holds nothing but white space, leaving the
+// first other byte unread.
+func bodyIsBlank(r *bufio.Reader) (bool, error) {
+	for {
+		b, err := r.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b)) {
+			return false, r.UnreadByte()
+		}
+	}
+}
+
+func importBodyError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+	}
+	return err
+}
+
+// importBatch is a batch of valid movies and the rows they came from.
+type importBatch struct {
+	movies []*data.Movie
+	rows   []int
+}
+
+// runImport validates every row with data.ValidateMovie, recording the
+// invalid ones on the job, and inserts the valid ones in batches of
+// importBatchSize. The job is marked finished once body has been read, or
+// failed if body couldn't be parsed.
+func (app *application) runImport(job *importJob, body io.Reader) {
+	var batch importBatch
+
+	rowFn := func(row int, movie *data.Movie, rowErrs map[string]string) {
+		if rowErrs == nil {
+			v := validator.New()
+			if data.ValidateMovie(v, movie); !v.Valid() {
+				rowErrs = v.Errors
+			}
+		}
+
+		app.imports.update(job, func(job *importJob) {
+			job.Processed++
+			if rowErrs != nil {
+				job.Failed++
+				job.Errors = append(job.Errors, importRowError{Row: row, Errors: rowErrs})
+				return
+			}
+			job.Valid++
+		})
+		if rowErrs != nil {
+			return
+		}
+
+		batch.movies = append(batch.movies, movie)
+		batch.rows = append(batch.rows, row)
+		if len(batch.movies) == importBatchSize {
+			app.insertImportBatch(job, batch)
+			batch = importBatch{}
+		}
+	}
+
+	var err error
+	switch job.Format {
+	case "csv":
+		err = parseMovieCSV(body, rowFn)
+	default:
+		err = parseMovieNDJSON(body, rowFn)
+	}
+
+	if len(batch.movies) > 0 {
+		app.insertImportBatch(job, batch)
+	}
+
+	finishedAt := time.Now()
+	app.imports.update(job, func(job *importJob) {
+		job.Status = importCompleted
+		if err != nil {
+			job.Status = importFailed
+			job.Errors = append(job.Errors, importRowError{Errors: map[string]string{"body": err.Error()}})
+		}
+		job.FinishedAt = &finishedAt
+	})
+}
+
+// insertImportBatch writes batch in one transaction, unless the job is a dry
+// run. A failed batch is reported against each of its rows and the import
+// carries on.
+func (app *application) insertImportBatch(job *importJob, batch importBatch) {
+	if job.DryRun {
+		return
+	}
+
+	err := app.models.Movies.InsertBatch(batch.movies)
+	if err == nil {
+		ids := make([]int64, len(batch.movies))
+		for i, movie := range batch.movies {
+			app.suggestions.Set(movie.ID, movie.Title, movie.Genres)
+			ids[i] = movie.ID
+		}
+		app.refreshSimilarities(ids...)
+	}
+
+	app.imports.update(job, func(job *importJob) {
+		if err != nil {
+			for _, row := range batch.rows {
+				job.Failed++
+				job.Errors = append(job.Errors, importRowError{Row: row, Errors: map[string]string{"batch": "could not be inserted"}})
+			}
+			return
+		}
+		job.Inserted += len(batch.movies)
+	})
+	if err != nil {
+		app.logger.PrintError(err, map[string]string{"import_id": strconv.FormatInt(job.ID, 10)})
+	}
+}
+
+// parseMovieCSV reads rows with a title,year,runtime,genres header. Genres
+// are comma separated within their cell and runtime uses the "<n> mins"
+// form accepted by the JSON API. A malformed record is reported as an error
+// on its row.
+func parseMovieCSV(r io.Reader, fn func(row int, movie *data.Movie, rowErrs map[string]string)) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("unable to read CSV header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("CSV header is missing the %q column", name)
+		}
+	}
+
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			fn(row, nil, map[string]string{"row": parseErr.Error()})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		cell := func(name string) string {
+			i := columns[name]
+			if i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		movie := &data.Movie{Title: cell("title")}
+		rowErrs := make(map[string]string)
+
+		if s := cell("year"); s != "" {
+			year, err := strconv.ParseInt(s, 10, 32)
+			if err != nil {
+				rowErrs["year"] = "must be an integer value"
+			}
+			movie.Year = int32(year)
+		}
+
+		if s := cell("runtime"); s != "" {
+			runtime, err := data.ParseRuntime(s)
+			if err != nil {
+				rowErrs["runtime"] = err.Error()
+			}
+			movie.Runtime = runtime
+		}
+
+		if s := cell("genres"); s != "" {
+			movie.Genres = []string{}
+			for _, genre := range strings.Split(s, ",") {
+				movie.Genres = append(movie.Genres, strings.TrimSpace(genre))
+			}
+		}
+
+		if len(rowErrs) == 0 {
+			rowErrs = nil
+		}
+		fn(row, movie, rowErrs)
+	}
+}
+
+// parseMovieNDJSON reads one JSON movie object per line, in the same shape
+// as the POST /v1/movies body. Blank lines are skipped.
+func parseMovieNDJSON(r io.Reader, fn func(row int, movie *data.Movie, rowErrs map[string]string)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1_048_576)
+
+	row := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		row++
+
+		var input struct {
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+		if err != nil {
+			fn(row, nil, map[string]string{"row": err.Error()})
+			continue
+		}
+
+		movie := &data.Movie{
+			Title:   input.Title,
+			Year:    input.Year,
+			Runtime: input.Runtime,
+			Genres:  input.Genres,
+		}
+		fn(row, movie, nil)
+	}
+
+	return scanner.Err()
+}
+
+func importFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case "text/csv":
+		return "csv"
+	case "application/x-ndjson", "application/jsonl", "application/jsonlines":
+		return "ndjson"
+	default:
+		return ""
+	}
+}
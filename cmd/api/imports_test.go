@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"greenlight.bcc/internal/assert"
+)
+
+func TestImportMovies(t *testing.T) {
+	app := newTestApplication(t, false)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		urlPath    string
+		body       string
+		wantCode   int
+		wantStatus string
+		wantReport string
+	}{
+		{
+			name:       "valid CSV",
+			urlPath:    "/v1/movies/import?format=csv",
+			body:       "title,year,runtime,genres\nCasablanca,1942,102 mins,\"drama,romance\"\n",
+			wantCode:   http.StatusAccepted,
+			wantStatus: `"status":"completed"`,
+			wantReport: `"valid":1,"inserted":1`,
+		},
+		{
+			name:       "CSV with invalid rows",
+			urlPath:    "/v1/movies/import?format=csv",
+			body:       "title,year,runtime,genres\n,1942,102 mins,drama\nCasablanca,1500,102,drama\n",
+			wantCode:   http.StatusAccepted,
+			wantStatus: `"status":"completed"`,
+			wantReport: `"failed":2`,
+		},
+		{
+			name:       "CSV missing column",
+			urlPath:    "/v1/movies/import?format=csv",
+			body:       "title,year\nCasablanca,1942\n",
+			wantCode:   http.StatusAccepted,
+			wantStatus: `"status":"failed"`,
+			wantReport: `missing the \"runtime\" column`,
+		},
+		{
+			name:       "valid NDJSON",
+			urlPath:    "/v1/movies/import?format=ndjson",
+			body:       "{\"title\":\"Casablanca\",\"year\":1942,\"runtime\":\"102 mins\",\"genres\":[\"drama\"]}\n\n{\"title\":\"Heat\",\"year\":1995,\"runtime\":\"170 mins\",\"genres\":[\"crime\"]}\n",
+			wantCode:   http.StatusAccepted,
+			wantStatus: `"status":"completed"`,
+			wantReport: `"inserted":2`,
+		},
+		{
+			name:       "NDJSON with unknown field",
+			urlPath:    "/v1/movies/import?format=ndjson",
+			body:       "{\"title\":\"Casablanca\",\"rating\":5}\n",
+			wantCode:   http.StatusAccepted,
+			wantStatus: `"status":"completed"`,
+			wantReport: `"row":1`,
+		},
+		{
+			name:       "error while inserting",
+			urlPath:    "/v1/movies/import?format=ndjson",
+			body:       "{\"title\":\"error\",\"year\":1942,\"runtime\":\"102 mins\",\"genres\":[\"drama\"]}\n",
+			wantCode:   http.StatusAccepted,
+			wantStatus: `"status":"completed"`,
+			wantReport: `"batch":"could not be inserted"`,
+		},
+		{
+			name:       "dry run",
+			urlPath:    "/v1/movies/import?format=csv&dry_run=true",
+			body:       "title,year,runtime,genres\nCasablanca,1942,102 mins,drama\n",
+			wantCode:   http.StatusAccepted,
+			wantStatus: `"dry_run":true`,
+			wantReport: `"valid":1,"inserted":0`,
+		},
+		{
+			name:       "CSV with malformed record",
+			urlPath:    "/v1/movies/import?format=csv",
+			body:       "title,year,runtime,genres\nCasa\"blanca,1942,102 mins,drama\nHeat,1995,170 mins,crime\n",
+			wantCode:   http.StatusAccepted,
+			wantStatus: `"status":"completed"`,
+			wantReport: `"valid":1,"inserted":1,"failed":1`,
+		},
+		{
+			name:     "body too large",
+			urlPath:  "/v1/movies/import?format=ndjson",
+			body:     "{\"title\":\"Casablanca\",\"year\":1942,\"runtime\":\"102 mins\",\"genres\":[\"drama\"]}\n" + strings.Repeat("\n", importMaxBytes),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "unknown format",
+			urlPath:  "/v1/movies/import?format=xml",
+			body:     "<movies/>",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "invalid dry run",
+			urlPath:  "/v1/movies/import?format=csv&dry_run=maybe",
+			body:     "title,year,runtime,genres\n",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "empty body",
+			urlPath:  "/v1/movies/import?format=csv",
+			body:     "",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, _ := ts.postForm(t, tt.urlPath, []byte(tt.body))
+			assert.Equal(t, code, tt.wantCode)
+			if code != http.StatusAccepted {
+				return
+			}
+
+			assert.Equal(t, headers.Get("Location"), fmt.Sprintf("/v1/imports/%d", i+1))
+
+			app.wg.Wait()
+
+			code, _, body := ts.get(t, headers.Get("Location"))
+			assert.Equal(t, code, http.StatusOK)
+			assert.StringContains(t, body, tt.wantStatus)
+			assert.StringContains(t, body, tt.wantReport)
+		})
+	}
+}
+
+func TestShowImport(t *testing.T) {
+	app := newTestApplication(t, false)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	app.imports.add(&importJob{UserID: 4, Status: importCompleted})
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{
+			name:     "another user's import",
+			urlPath:  "/v1/imports/1",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "non-existent import",
+			urlPath:  "/v1/imports/100",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "invalid id",
+			urlPath:  "/v1/imports/foo",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"greenlight.bcc/internal/jsonlog"
+)
+
+// logOutput is where the logger writes, built from the -log-* settings.
+type logOutput struct {
+	writer io.Writer
+	// async is the queue in front of the outputs, or nil when logging is
+	// synchronous.
+	async   *jsonlog.AsyncWriter
+	closers []io.Closer
+}
+
+// newLogOutput opens the outputs named by -log-outputs. When -log-buffer is
+// set they are written to from a background goroutine, so that a slow disk
+// or syslog daemon doesn't hold up requests.
+func newLogOutput(cfg config) (*logOutput, error) {
+	out := &logOutput{}
+
+	var writers []io.Writer
+	for _, name := range cfg.logs.outputs {
+		switch name {
+		case "stdout":
+			writers = append(writers, os.Stdout)
+		case "file":
+			f, err := jsonlog.OpenRotatingFile(cfg.logs.file, jsonlog.RotateOptions{
+				MaxSize:    int64(cfg.logs.maxSize) << 20,
+				Interval:   cfg.logs.rotateInterval,
+				MaxBackups: cfg.logs.maxBackups,
+				MaxAge:     cfg.logs.maxAge,
+				Compress:   cfg.logs.compress,
+			})
+			if err != nil {
+				out.Close()
+				return nil, err
+			}
+			writers = append(writers, f)
+			out.closers = append(out.closers, f)
+		case "syslog":
+			s, err := jsonlog.DialSyslog(cfg.logs.syslogAddress, cfg.logs.syslogTag)
+			if err != nil {
+				out.Close()
+				return nil, err
+			}
+			writers = append(writers, s)
+			out.closers = append(out.closers, s)
+		}
+	}
+
+	out.writer = writers[0]
+	if len(writers) > 1 {
+		out.writer = jsonlog.MultiWriter(writers...)
+	}
+
+	if cfg.logs.buffer > 0 {
+		out.async = jsonlog.NewAsyncWriter(out.writer, cfg.logs.buffer)
+		out.writer = out.async
+	}
+
+	return out, nil
+}
+
+// Close writes out anything still queued and closes the outputs.
+func (o *logOutput) Close() error {
+	var errs []error
+	if o.async != nil {
+		errs = append(errs, o.async.Close())
+	}
+	for _, c := range o.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// applyLogSettings sets the logger's levels and sampling from cfg. It is
+// used both at startup and on reload.
+func applyLogSettings(logger *jsonlog.Logger, cfg *config) {
+	logger.SetLevel(cfg.logLevel)
+	logger.SetTraceLevel(cfg.logStackLevel)
+	logger.SetSampling(jsonlog.Sampling{
+		Tick:       cfg.logs.sampleTick,
+		First:      cfg.logs.sampleFirst,
+		Thereafter: cfg.logs.sampleThereafter,
+	})
+}
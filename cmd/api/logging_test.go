@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"greenlight.bcc/internal/assert"
+	"greenlight.bcc/internal/jsonlog"
+)
+
+type logLine struct {
+	Level      string         `json:"level"`
+	Message    string         `json:"message"`
+	Properties map[string]any `json:"properties"`
+	Trace      string         `json:"trace"`
+}
+
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []logLine {
+	t.Helper()
+
+	var lines []logLine
+	for _, raw := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if raw == "" {
+			continue
+		}
+		var line logLine
+		assert.NilError(t, json.Unmarshal([]byte(raw), &line))
+		lines = append(lines, line)
+	}
+	buf.Reset()
+	return lines
+}
+
+func TestLoggerLevels(t *testing.T) {
+	tests := []struct {
+		name     string
+		minLevel jsonlog.Level
+		want     []string
+	}{
+		{"debug", jsonlog.LevelDebug, []string{"DEBUG", "INFO", "WARN", "ERROR"}},
+		{"info", jsonlog.LevelInfo, []string{"INFO", "WARN", "ERROR"}},
+		{"warn", jsonlog.LevelWarn, []string{"WARN", "ERROR"}},
+		{"error", jsonlog.LevelError, []string{"ERROR"}},
+		{"off", jsonlog.LevelOff, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := jsonlog.New(&buf, tt.minLevel)
+
+			logger.Debug("one")
+			logger.Info("two")
+			logger.Warn("three")
+			logger.Error("four")
+
+			var got []string
+			for _, line := range decodeLogLines(t, &buf) {
+				got = append(got, line.Level)
+			}
+			assert.Equal(t, strings.Join(got, " "), strings.Join(tt.want, " "))
+		})
+	}
+
+	t.Run("set at runtime", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := jsonlog.New(&buf, jsonlog.LevelInfo)
+		child := logger.With(jsonlog.String("component", "test"))
+
+		child.Debug("hidden")
+		logger.SetLevel(jsonlog.LevelDebug)
+		child.Debug("shown")
+
+		lines := decodeLogLines(t, &buf)
+		assert.Equal(t, len(lines), 1)
+		assert.Equal(t, lines[0].Message, "shown")
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		for _, name := range []string{"debug", "Info", "WARN", "error", "fatal", "off"} {
+			level, err := jsonlog.ParseLevel(name)
+			assert.NilError(t, err)
+			assert.Equal(t, level.String(), strings.ToUpper(name))
+		}
+
+		_, err := jsonlog.ParseLevel("verbose")
+		assert.Equal(t, err != nil, true)
+	})
+}
+
+func TestLoggerAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := jsonlog.New(&buf, jsonlog.LevelInfo)
+
+	child := logger.With(
+		jsonlog.String("component", "importer"),
+		jsonlog.Group("job", jsonlog.Int("id", 7), jsonlog.Group("input", jsonlog.String("format", "csv"))),
+	)
+	child.Info("imported",
+		jsonlog.Int("rows", 42),
+		jsonlog.Float64("ratio", 0.5),
+		jsonlog.Bool("dry_run", false),
+		jsonlog.Duration("took", 1500*time.Millisecond),
+		jsonlog.Group("job", jsonlog.String("source", "upload"), jsonlog.Group("input", jsonlog.Int("bytes", 512))),
+		jsonlog.Err(errors.New("partial")),
+	)
+	logger.Info("parent")
+
+	lines := decodeLogLines(t, &buf)
+	assert.Equal(t, len(lines), 2)
+
+	props := lines[0].Properties
+	assert.Equal(t, props["component"], any("importer"))
+	assert.Equal(t, props["rows"], any(float64(42)))
+	assert.Equal(t, props["ratio"], any(0.5))
+	assert.Equal(t, props["dry_run"], any(false))
+	assert.Equal(t, props["took"], any("1.5s"))
+	assert.Equal(t, props["error"], any("partial"))
+
+	job, _ := props["job"].(map[string]any)
+	assert.Equal(t, job["id"], any(float64(7)))
+	assert.Equal(t, job["source"], any("upload"))
+
+	input, _ := job["input"].(map[string]any)
+	assert.Equal(t, input["format"], any("csv"))
+	assert.Equal(t, input["bytes"], any(float64(512)))
+
+	// Binding attributes to a child doesn't change the parent.
+	assert.Equal(t, len(lines[1].Properties), 0)
+
+	t.Run("string properties", func(t *testing.T) {
+		logger.PrintInfo("legacy", map[string]string{"count": "3"})
+
+		lines := decodeLogLines(t, &buf)
+		assert.Equal(t, lines[0].Properties["count"], any("3"))
+	})
+}
+
+func TestLoggerTrace(t *testing.T) {
+	tests := []struct {
+		name       string
+		traceLevel jsonlog.Level
+		log        func(*jsonlog.Logger)
+		wantTrace  bool
+	}{
+		{"error by default", jsonlog.LevelError, func(l *jsonlog.Logger) { l.Error("failed") }, true},
+		{"not below trace level", jsonlog.LevelError, func(l *jsonlog.Logger) { l.Warn("careful") }, false},
+		{"off", jsonlog.LevelOff, func(l *jsonlog.Logger) { l.Error("failed") }, false},
+		{"lowered", jsonlog.LevelWarn, func(l *jsonlog.Logger) { l.Warn("careful") }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := jsonlog.New(&buf, jsonlog.LevelInfo)
+			logger.SetTraceLevel(tt.traceLevel)
+
+			tt.log(logger)
+
+			lines := decodeLogLines(t, &buf)
+			assert.Equal(t, lines[0].Trace != "", tt.wantTrace)
+		})
+	}
+}
+
+func TestLoggerSlogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := jsonlog.New(&buf, jsonlog.LevelInfo)
+	log := slog.New(logger.Handler())
+
+	t.Run("levels", func(t *testing.T) {
+		log.Debug("hidden")
+		log.Info("info")
+		log.Warn("warn")
+		log.Log(context.Background(), slog.LevelError+4, "error")
+
+		var got []string
+		for _, line := range decodeLogLines(t, &buf) {
+			got = append(got, line.Level+" "+line.Message)
+		}
+		assert.Equal(t, strings.Join(got, ", "), "INFO info, WARN warn, ERROR error")
+	})
+
+	t.Run("attrs and groups", func(t *testing.T) {
+		log.With("component", "lib").
+			WithGroup("request").
+			With("id", 9).
+			WithGroup("timing").
+			Info("done", "took", 2*time.Second, slog.Group("", slog.Bool("ok", true)), slog.Group("empty"))
+
+		lines := decodeLogLines(t, &buf)
+		props := lines[0].Properties
+		assert.Equal(t, props["component"], any("lib"))
+
+		request, _ := props["request"].(map[string]any)
+		assert.Equal(t, request["id"], any(float64(9)))
+
+		timing, _ := request["timing"].(map[string]any)
+		assert.Equal(t, timing["took"], any("2s"))
+		assert.Equal(t, timing["ok"], any(true))
+
+		_, ok := timing["empty"]
+		assert.Equal(t, ok, false)
+	})
+
+	t.Run("error values", func(t *testing.T) {
+		log.Error("failed", "err", errors.New("boom"))
+
+		lines := decodeLogLines(t, &buf)
+		assert.Equal(t, lines[0].Properties["err"], any("boom"))
+	})
+}
+
+func TestLoggerSampling(t *testing.T) {
+	var buf bytes.Buffer
+	logger := jsonlog.New(&buf, jsonlog.LevelDebug)
+	logger.SetSampling(jsonlog.Sampling{Tick: time.Hour, First: 2, Thereafter: 3})
+	unsampled := logger.Unsampled().With(jsonlog.String("component", "access"))
+
+	for i := 0; i < 10; i++ {
+		logger.Info("repeated")
+		logger.Error("failing")
+		unsampled.Info("request")
+	}
+	logger.Info("different")
+
+	counts := make(map[string]int)
+	for _, line := range decodeLogLines(t, &buf) {
+		counts[line.Message]++
+	}
+
+	// The first two, then the 5th and 8th.
+	assert.Equal(t, counts["repeated"], 4)
+	assert.Equal(t, counts["failing"], 10)
+	assert.Equal(t, counts["different"], 1)
+	assert.Equal(t, counts["request"], 10)
+	assert.Equal(t, logger.Sampled(), uint64(6))
+
+	logger.SetSampling(jsonlog.Sampling{})
+	for i := 0; i < 10; i++ {
+		logger.Info("repeated")
+	}
+	assert.Equal(t, len(decodeLogLines(t, &buf)), 10)
+}
+
+// blockingWriter holds up every write until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	lines   []string
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lines = append(w.lines, string(p))
+	return len(p), nil
+}
+
+func TestAsyncWriter(t *testing.T) {
+	slow := &blockingWriter{release: make(chan struct{})}
+	async := jsonlog.NewAsyncWriter(slow, 2)
+	logger := jsonlog.New(async, jsonlog.LevelInfo)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			logger.Info("busy")
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging blocked on a slow output")
+	}
+
+	close(slow.release)
+	assert.NilError(t, async.Flush())
+
+	// One entry is being written while two wait in the queue, so at least
+	// seven are dropped.
+	assert.Equal(t, async.Dropped() >= 7, true)
+	assert.Equal(t, uint64(len(slow.lines))+async.Dropped(), uint64(10))
+
+	assert.NilError(t, async.Close())
+	logger.Info("after close")
+	assert.Equal(t, uint64(len(slow.lines))+async.Dropped(), uint64(11))
+
+	logger.Error("error after close")
+	assert.Equal(t, uint64(len(slow.lines))+async.Dropped(), uint64(12))
+	assert.StringContains(t, slow.lines[len(slow.lines)-1], "error after close")
+}
+
+func TestAsyncWriterKeepsWarnings(t *testing.T) {
+	slow := &blockingWriter{release: make(chan struct{})}
+	async := jsonlog.NewAsyncWriter(slow, 2)
+	logger := jsonlog.New(async, jsonlog.LevelInfo)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			logger.Warn("busy")
+		}
+	}()
+
+	// Warnings wait for room in the queue rather than being dropped.
+	select {
+	case <-done:
+		t.Fatal("warnings didn't wait for the slow output")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(slow.release)
+	<-done
+	assert.NilError(t, async.Close())
+
+	assert.Equal(t, async.Dropped(), uint64(0))
+	assert.Equal(t, len(slow.lines), 10)
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Run("size and retention", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "api.log")
+
+		f, err := jsonlog.OpenRotatingFile(path, jsonlog.RotateOptions{MaxSize: 20, MaxBackups: 2})
+		assert.NilError(t, err)
+
+		for i := 0; i < 5; i++ {
+			_, err := f.Write([]byte(strings.Repeat(strconv.Itoa(i), 15) + "\n"))
+			assert.NilError(t, err)
+			// Backups are named to the millisecond.
+			time.Sleep(2 * time.Millisecond)
+		}
+		assert.NilError(t, f.Close())
+
+		current, err := os.ReadFile(path)
+		assert.NilError(t, err)
+		assert.Equal(t, string(current), strings.Repeat("4", 15)+"\n")
+
+		backups, err := filepath.Glob(filepath.Join(dir, "api-*.log"))
+		assert.NilError(t, err)
+		assert.Equal(t, len(backups), 2)
+
+		sort.Strings(backups)
+		newest, err := os.ReadFile(backups[1])
+		assert.NilError(t, err)
+		assert.Equal(t, string(newest), strings.Repeat("3", 15)+"\n")
+	})
+
+	t.Run("relative path", func(t *testing.T) {
+		wd, err := os.Getwd()
+		assert.NilError(t, err)
+		assert.NilError(t, os.Chdir(t.TempDir()))
+		defer os.Chdir(wd)
+
+		f, err := jsonlog.OpenRotatingFile("./logs/api.log", jsonlog.RotateOptions{MaxSize: 20, MaxBackups: 1})
+		assert.NilError(t, err)
+
+		for i := 0; i < 4; i++ {
+			_, err := f.Write([]byte(strings.Repeat(strconv.Itoa(i), 15) + "\n"))
+			assert.NilError(t, err)
+			time.Sleep(2 * time.Millisecond)
+		}
+		assert.NilError(t, f.Close())
+
+		backups, err := filepath.Glob(filepath.Join("logs", "api-*.log"))
+		assert.NilError(t, err)
+		assert.Equal(t, len(backups), 1)
+	})
+
+	t.Run("interval and compression", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "api.log")
+
+		f, err := jsonlog.OpenRotatingFile(path, jsonlog.RotateOptions{Interval: time.Millisecond, Compress: true})
+		assert.NilError(t, err)
+
+		_, err = f.Write([]byte("first\n"))
+		assert.NilError(t, err)
+		time.Sleep(5 * time.Millisecond)
+		_, err = f.Write([]byte("second\n"))
+		assert.NilError(t, err)
+		assert.NilError(t, f.Close())
+
+		backups, err := filepath.Glob(filepath.Join(dir, "api-*.log.gz"))
+		assert.NilError(t, err)
+		assert.Equal(t, len(backups), 1)
+
+		gz, err := os.Open(backups[0])
+		assert.NilError(t, err)
+		defer gz.Close()
+		zr, err := gzip.NewReader(gz)
+		assert.NilError(t, err)
+		content, err := io.ReadAll(zr)
+		assert.NilError(t, err)
+		assert.Equal(t, string(content), "first\n")
+
+		uncompressed, err := filepath.Glob(filepath.Join(dir, "api-*.log"))
+		assert.NilError(t, err)
+		assert.Equal(t, len(uncompressed), 0)
+	})
+
+	t.Run("recovers from a failed rotation", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "logs")
+		path := filepath.Join(dir, "api.log")
+
+		f, err := jsonlog.OpenRotatingFile(path, jsonlog.RotateOptions{MaxSize: 10})
+		assert.NilError(t, err)
+
+		_, err = f.Write([]byte("0123456789\n"))
+		assert.NilError(t, err)
+
+		// With the directory gone the new file can't be opened.
+		assert.NilError(t, os.RemoveAll(dir))
+		_, err = f.Write([]byte("lost\n"))
+		assert.Equal(t, err != nil, true)
+
+		assert.NilError(t, os.MkdirAll(dir, 0o755))
+		_, err = f.Write([]byte("kept\n"))
+		assert.NilError(t, err)
+		assert.NilError(t, f.Close())
+
+		content, err := os.ReadFile(path)
+		assert.NilError(t, err)
+		assert.Equal(t, string(content), "kept\n")
+
+		_, err = f.Write([]byte("closed\n"))
+		assert.Equal(t, err != nil, true)
+	})
+}
+
+func TestSyslog(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "log.sock")
+
+	conn, err := net.ListenPacket("unixgram", addr)
+	if err != nil {
+		t.Skipf("unix datagram sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+
+	s, err := jsonlog.DialSyslog(addr, "greenlight")
+	assert.NilError(t, err)
+	defer s.Close()
+
+	logger := jsonlog.New(s, jsonlog.LevelInfo)
+	logger.SetTraceLevel(jsonlog.LevelOff)
+
+	tests := []struct {
+		log          func()
+		wantPriority string
+	}{
+		{func() { logger.Info("started") }, "<30>"},
+		{func() { logger.Warn("slow") }, "<28>"},
+		{func() { logger.Error("failed") }, "<27>"},
+	}
+
+	buf := make([]byte, 4096)
+	for _, tt := range tests {
+		tt.log()
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		assert.NilError(t, err)
+
+		msg := string(buf[:n])
+		assert.Equal(t, strings.HasPrefix(msg, tt.wantPriority), true)
+		assert.StringContains(t, msg, " greenlight["+strconv.Itoa(os.Getpid())+"]: {")
+	}
+}
+
+func TestNewLogOutput(t *testing.T) {
+	var cfg config
+	cfg.logs.outputs = []string{"stdout", "file"}
+	cfg.logs.file = filepath.Join(t.TempDir(), "logs", "api.log")
+	cfg.logs.buffer = 16
+
+	output, err := newLogOutput(cfg)
+	assert.NilError(t, err)
+
+	logger := jsonlog.New(output.writer, jsonlog.LevelInfo)
+	logger.Info("to the file", jsonlog.Int("n", 1))
+	assert.NilError(t, output.Close())
+
+	content, err := os.ReadFile(cfg.logs.file)
+	assert.NilError(t, err)
+	assert.StringContains(t, string(content), `"message":"to the file"`)
+
+	cfg.logs.outputs = []string{"syslog"}
+	cfg.logs.syslogAddress = filepath.Join(t.TempDir(), "missing.sock")
+	_, err = newLogOutput(cfg)
+	assert.Equal(t, err != nil, true)
+}
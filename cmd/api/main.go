@@ -3,25 +3,36 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"expvar"
 	"flag"
+	"log/slog"
 	"os"
 	"runtime"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/lib/pq"
 	"greenlight.bcc/internal/data"
 	"greenlight.bcc/internal/jsonlog"
 	"greenlight.bcc/internal/mailer" // New import
+	"greenlight.bcc/internal/suggest"
+	"greenlight.bcc/internal/trace"
 )
 
 const version = "1.0.0"
 
 type config struct {
-	port int
-	env  string
+	file           string
+	printConfig    bool
+	port           int
+	env            string
+	logLevel       jsonlog.Level
+	logStackLevel  jsonlog.Level
+	requireIfMatch bool
+	shutdownDelay  time.Duration
 	db   struct {
 		dsn          string
 		maxOpenConns int
@@ -43,46 +54,109 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	stats struct {
+		cacheTTL time.Duration
+	}
+	migrate struct {
+		action string
+		check  bool
+	}
+	trace struct {
+		exporter string
+		endpoint string
+		file     string
+	}
+	admin struct {
+		addr string
+	}
+	health struct {
+		timeout            time.Duration
+		cacheTTL           time.Duration
+		maxBackgroundTasks int
+	}
+	logs struct {
+		outputs          []string
+		file             string
+		maxSize          int
+		rotateInterval   time.Duration
+		maxBackups       int
+		maxAge           time.Duration
+		compress         bool
+		syslogAddress    string
+		syslogTag        string
+		buffer           int
+		sampleTick       time.Duration
+		sampleFirst      int
+		sampleThereafter int
+	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config      config
+	logger      *jsonlog.Logger
+	models      data.Models
+	mailer      mailer.Mailer
+	imports     *importRegistry
+	suggestions *suggest.Index
+	stats       *statsCache
+	telemetry   *telemetry
+	tracer      *trace.Tracer
+	health      *health
+	wg          sync.WaitGroup
+	// startupSettings is the effective configuration at startup, with
+	// secrets redacted, as shown by /debug/config.
+	startupSettings map[string]string
+	// live holds the configuration swapped in by the last SIGHUP reload.
+	live atomic.Pointer[config]
 }
 
 func main() {
 
-	var cfg config
-
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
-	flag.StringVar(&cfg.db.dsn, "db-dsn",  os.Getenv("GREENLIGHT_DB_DSN"), "PostgreSQL DSN")
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	cfg, fs, err := loadConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		fs.SetOutput(os.Stderr)
+		fs.Usage()
+		return
+	}
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	if cfg.printConfig {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		enc.SetEscapeHTML(false)
+		err = enc.Encode(effectiveConfig(fs))
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
 
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 25, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "6b1b71f5d6687d", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "d6db3cd88fa14c", "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.alexedwards.net>", "SMTP sender")
+	output, err := newLogOutput(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer output.Close()
 
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
-		cfg.cors.trustedOrigins = strings.Fields(val)
-		return nil
-	})
+	logger = jsonlog.New(output.writer, cfg.logLevel)
+	applyLogSettings(logger, &cfg)
+	slog.SetDefault(slog.New(logger.Handler()))
 
-	flag.Parse()
+	logger.PrintInfo("configuration loaded", effectiveConfig(fs))
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	var migrateCmd migrateCommand
+	if cfg.migrate.action != "" {
+		migrateCmd, err = parseMigrateCommand(cfg.migrate.action, fs.Args())
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
 
 	db, err := openDB(cfg)
 	if err != nil {
@@ -93,6 +167,21 @@ func main() {
 
 	logger.PrintInfo("database connection pool established", nil)
 
+	if cfg.migrate.action != "" {
+		err = runMigrateCommand(db, logger, migrateCmd)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		return
+	}
+
+	if cfg.migrate.check {
+		err = checkMigrations(db)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
 	expvar.NewString("version").Set(version)
 	
 	expvar.Publish("goroutines", expvar.Func(func() any {
@@ -107,13 +196,45 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	exporter, closeExporter, err := newTraceExporter(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer closeExporter()
+
+	tracer := trace.New(exporter, func(err error) {
+		logger.PrintError(err, map[string]string{"component": "tracing"})
+	})
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:      cfg,
+		logger:      logger,
+		models:      data.NewModels(db),
+		mailer:      mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		imports:     newImportRegistry(),
+		suggestions: suggest.New(),
+		stats:       newStatsCache(),
+		telemetry:   newTelemetry(),
+		tracer:      tracer,
 	}
 
+	app.health = app.newHealth(db)
+	app.startupSettings = effectiveConfig(fs)
+	app.telemetry.registerDB(db)
+	app.telemetry.registerLogs(logger, output.async)
+
+	err = app.loadSuggestions()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	app.background(func() {
+		err := app.models.Similarities.EnsureBuilt()
+		if err != nil {
+			logger.PrintError(err, nil)
+		}
+	})
+
 	err = app.serve()
 	if err != nil {
 		logger.PrintFatal(err, nil)
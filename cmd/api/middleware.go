@@ -14,9 +14,29 @@ import (
 	"github.com/felixge/httpsnoop"
 	"golang.org/x/time/rate"
 	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/jsonlog"
 	"greenlight.bcc/internal/validator"
 )
 
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var err error
+			id, err = newRequestID()
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -57,7 +77,8 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		}
 	}()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
+		cfg := app.liveConfig()
+		if cfg.limiter.enabled && !isProbe(r) {
 			ip, _, err := net.SplitHostPort(r.RemoteAddr)
 			if err != nil {
 				app.serverErrorResponse(w, r, err)
@@ -66,10 +87,15 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			mu.Lock()
 			if _, found := clients[ip]; !found {
 				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+					limiter: rate.NewLimiter(rate.Limit(cfg.limiter.rps), cfg.limiter.burst),
 				}
 			}
 
+			// Clients seen before a reload start again under the new limits.
+			if clients[ip].limiter.Limit() != rate.Limit(cfg.limiter.rps) || clients[ip].limiter.Burst() != cfg.limiter.burst {
+				clients[ip].limiter = rate.NewLimiter(rate.Limit(cfg.limiter.rps), cfg.limiter.burst)
+			}
+
 			clients[ip].lastSeen = time.Now()
 			if !clients[ip].limiter.Allow() {
 				mu.Unlock()
@@ -108,7 +134,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.modelsFor(r).Users.GetForToken(data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -155,7 +181,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -177,15 +203,16 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 
 		w.Header().Add("Vary", "Access-Control-Request-Method")
 		origin := r.Header.Get("Origin")
+		trustedOrigins := app.liveConfig().cors.trustedOrigins
 		if origin != "" {
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
+			for i := range trustedOrigins {
+				if origin == trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
 
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key, If-Match, If-None-Match")
 
 						w.WriteHeader(http.StatusOK)
 						return
@@ -198,27 +225,96 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) metrics(next http.Handler) http.Handler {
-	if app.config.env == "testing" {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			next.ServeHTTP(w, r)
-		})
-	}
+// metrics is the outermost middleware. It labels each request with its route
+// pattern, records the Prometheus and expvar metrics for it, and writes the
+// access log line once the response has been sent.
+func (app *application) metrics(patterns *routePatterns, next http.Handler) http.Handler {
+	var (
+		totalRequestsReceived           *expvar.Int
+		totalResponsesSent              *expvar.Int
+		totalProcessingTimeMicroseconds *expvar.Int
+		totalResponsesSentByStatus      *expvar.Map
+	)
 
-	totalRequestsReceived := expvar.NewInt("total_requests_received")
-	totalResponsesSent := expvar.NewInt("total_responses_sent")
-	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_μs")
-	totalResponsesSentByStatus := expvar.NewMap("total_responses_sent_by_status")
+	// expvar panics if a name is published twice, which would happen with
+	// every test server.
+	publishExpvar := app.config.env != "testing"
+	if publishExpvar {
+		totalRequestsReceived = expvar.NewInt("total_requests_received")
+		totalResponsesSent = expvar.NewInt("total_responses_sent")
+		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
+		totalResponsesSentByStatus = expvar.NewMap("total_responses_sent_by_status")
+	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		totalRequestsReceived.Add(1)
+		info := &requestInfo{route: unmatchedRoute}
+		r = app.contextSetRequestInfo(r, info)
+		patterns.match(r)
+
+		if publishExpvar {
+			totalRequestsReceived.Add(1)
+		}
 
 		metrics := httpsnoop.CaptureMetrics(next, w, r)
 
-		totalResponsesSent.Add(1)
+		status := strconv.Itoa(metrics.Code)
+		method := metricMethod(r.Method)
+		app.telemetry.requests.Inc(info.route, method, status)
+		app.telemetry.requestDuration.Observe(metrics.Duration.Seconds(), info.route, method, status)
 
-		totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
+		if publishExpvar {
+			totalResponsesSent.Add(1)
+			totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
+			totalResponsesSentByStatus.Add(status, 1)
+		}
 
-		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
+		app.logAccess(r, info, metrics)
 	})
 }
+
+// metricMethod returns the method to label a request's metrics with.
+// Clients can send any token as the method, so anything but the standard
+// methods is counted as "other" to keep the number of series bounded.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
+
+// logAccess writes one log line per request. Every request is logged, even
+// when sampling is thinning out other repetitive entries.
+func (app *application) logAccess(r *http.Request, info *requestInfo, metrics httpsnoop.Metrics) {
+	attrs := []jsonlog.Attr{
+		jsonlog.String("request_id", info.requestID),
+		jsonlog.String("method", r.Method),
+		jsonlog.String("url", r.URL.RequestURI()),
+		jsonlog.String("route", info.route),
+		jsonlog.Int("status", metrics.Code),
+		jsonlog.Float64("duration_ms", float64(metrics.Duration.Microseconds())/1000),
+		jsonlog.Int64("bytes", metrics.Written),
+		jsonlog.String("client_ip", clientIP(r)),
+	}
+
+	if info.userID != 0 {
+		attrs = append(attrs, jsonlog.Int64("user_id", info.userID))
+	}
+	if info.traceID != "" {
+		attrs = append(attrs, jsonlog.String("trace_id", info.traceID))
+	}
+
+	app.logger.Unsampled().Info("request", attrs...)
+}
+
+// clientIP returns the address the request came from. X-Forwarded-For is
+// ignored because the server doesn't know which proxies to trust.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
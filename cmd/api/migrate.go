@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"greenlight.bcc/internal/jsonlog"
+	"greenlight.bcc/internal/migrate"
+	"greenlight.bcc/migrations"
+)
+
+type migrateCommand struct {
+	action  string
+	version int64
+}
+
+// parseMigrateCommand reads the -migrate flag value and, for "to", the target
+// version from the first positional argument.
+func parseMigrateCommand(action string, args []string) (migrateCommand, error) {
+	cmd := migrateCommand{action: action}
+
+	switch action {
+	case "up", "down", "status":
+		if len(args) > 0 {
+			return cmd, fmt.Errorf("-migrate %s takes no arguments", action)
+		}
+	case "to":
+		if len(args) != 1 {
+			return cmd, errors.New("-migrate to needs exactly one version")
+		}
+
+		version, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || version < 0 {
+			return cmd, fmt.Errorf("-migrate to: invalid version %q", args[0])
+		}
+		cmd.version = version
+	default:
+		return cmd, fmt.Errorf("-migrate must be one of up, down, status or to, got %q", action)
+	}
+
+	return cmd, nil
+}
+
+func runMigrateCommand(db *sql.DB, logger *jsonlog.Logger, cmd migrateCommand) error {
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	var ran []migrate.Migration
+
+	switch cmd.action {
+	case "up":
+		ran, err = m.Up()
+	case "down":
+		ran, err = m.Down()
+	case "to":
+		ran, err = m.To(cmd.version)
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		logger.PrintInfo("migration status", map[string]string{
+			"current": strconv.FormatInt(status.Current, 10),
+			"latest":  strconv.FormatInt(status.Latest, 10),
+			"dirty":   strconv.FormatBool(status.Dirty),
+			"pending": strconv.Itoa(len(status.Pending)),
+		})
+		return nil
+	}
+
+	for _, migration := range ran {
+		logger.PrintInfo("applied migration", map[string]string{
+			"direction": cmd.action,
+			"version":   strconv.FormatInt(migration.Version, 10),
+			"name":      migration.Name,
+		})
+	}
+
+	return err
+}
+
+// checkMigrations refuses to let the server start against a database whose
+// schema is dirty or hasn't had every embedded migration applied.
+func checkMigrations(db *sql.DB) error {
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	return migrationError(status)
+}
+
+// migrationError describes why the schema in status isn't usable, or
+// returns nil if it is.
+func migrationError(status migrate.Status) error {
+	if status.Dirty {
+		return migrate.ErrDirty
+	}
+
+	if status.Behind() {
+		return fmt.Errorf("database schema is at version %d but %d is required; run with -migrate up", status.Current, status.Latest)
+	}
+
+	return nil
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"greenlight.bcc/internal/assert"
+	"greenlight.bcc/internal/migrate"
+	"greenlight.bcc/migrations"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	loaded, err := migrate.Load(migrations.FS)
+	assert.NilError(t, err)
+
+	assert.Equal(t, len(loaded) > 0, true)
+
+	for i, m := range loaded {
+		assert.Equal(t, m.Version, int64(i+1))
+		assert.Equal(t, strings.TrimSpace(m.Up) != "", true)
+		assert.Equal(t, strings.TrimSpace(m.Down) != "", true)
+	}
+}
+
+func TestParseMigrateCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      string
+		args        []string
+		wantVersion int64
+		wantErr     string
+	}{
+		{
+			name:   "up",
+			action: "up",
+		},
+		{
+			name:   "status",
+			action: "status",
+		},
+		{
+			name:        "to",
+			action:      "to",
+			args:        []string{"5"},
+			wantVersion: 5,
+		},
+		{
+			name:        "to zero",
+			action:      "to",
+			args:        []string{"0"},
+			wantVersion: 0,
+		},
+		{
+			name:    "to without version",
+			action:  "to",
+			wantErr: "needs exactly one version",
+		},
+		{
+			name:    "to negative version",
+			action:  "to",
+			args:    []string{"-1"},
+			wantErr: "invalid version",
+		},
+		{
+			name:    "down with arguments",
+			action:  "down",
+			args:    []string{"2"},
+			wantErr: "takes no arguments",
+		},
+		{
+			name:    "unknown action",
+			action:  "sideways",
+			wantErr: "must be one of",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := parseMigrateCommand(tt.action, tt.args)
+
+			if tt.wantErr != "" {
+				assert.Equal(t, err != nil, true)
+				assert.StringContains(t, err.Error(), tt.wantErr)
+				return
+			}
+
+			assert.NilError(t, err)
+			assert.Equal(t, cmd.action, tt.action)
+			assert.Equal(t, cmd.version, tt.wantVersion)
+		})
+	}
+}
+
+// fakeMigrationDB is a database/sql driver that understands just the
+// statements the migrator sends, so that migrations can be run without a
+// PostgreSQL server. It records every migration body it executes, and the
+// schema_migrations row each transaction leaves behind.
+type fakeMigrationDB struct {
+	mu       sync.Mutex
+	table    bool
+	hasRow   bool
+	version  int64
+	dirty    bool
+	failOn   string
+	executed []string
+	locked   bool
+}
+
+func (db *fakeMigrationDB) Connect(ctx context.Context) (driver.Conn, error) {
+	return &fakeMigrationConn{db: db}, nil
+}
+
+func (db *fakeMigrationDB) Driver() driver.Driver {
+	return nil
+}
+
+type fakeMigrationConn struct {
+	db *fakeMigrationDB
+	tx *fakeMigrationTx
+}
+
+type fakeMigrationTx struct {
+	conn                 *fakeMigrationConn
+	table, hasRow, dirty bool
+	version              int64
+	executed             int
+}
+
+func (c *fakeMigrationConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("fake: prepared statements are not supported")
+}
+
+func (c *fakeMigrationConn) Close() error {
+	return nil
+}
+
+func (c *fakeMigrationConn) Begin() (driver.Tx, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	c.tx = &fakeMigrationTx{conn: c, table: db.table, hasRow: db.hasRow, dirty: db.dirty, version: db.version, executed: len(db.executed)}
+	return c.tx, nil
+}
+
+func (tx *fakeMigrationTx) Commit() error {
+	tx.conn.tx = nil
+	return nil
+}
+
+func (tx *fakeMigrationTx) Rollback() error {
+	if tx.conn.tx != tx {
+		return nil
+	}
+	tx.conn.tx = nil
+
+	db := tx.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.table, db.hasRow, db.dirty, db.version = tx.table, tx.hasRow, tx.dirty, tx.version
+	db.executed = db.executed[:tx.executed]
+	return nil
+}
+
+func (c *fakeMigrationConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	query = strings.TrimSpace(query)
+	switch {
+	case strings.HasPrefix(query, "SELECT pg_advisory_lock"):
+		db.locked = true
+	case strings.HasPrefix(query, "SELECT pg_advisory_unlock"):
+		db.locked = false
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS schema_migrations"):
+		db.table = true
+	case query == "DELETE FROM schema_migrations":
+		db.hasRow, db.version = false, 0
+	case strings.HasPrefix(query, "INSERT INTO schema_migrations"):
+		db.hasRow, db.version, db.dirty = true, args[0].Value.(int64), false
+	case query == db.failOn:
+		return nil, errors.New("fake: syntax error")
+	default:
+		db.executed = append(db.executed, query)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeMigrationConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "to_regclass('schema_migrations')"):
+		return &fakeMigrationRows{columns: []string{"exists"}, values: [][]driver.Value{{db.table}}}, nil
+	case strings.Contains(query, "SELECT version, dirty FROM schema_migrations"):
+		if !db.table {
+			return nil, errors.New(`fake: relation "schema_migrations" does not exist`)
+		}
+		rows := &fakeMigrationRows{columns: []string{"version", "dirty"}}
+		if db.hasRow {
+			rows.values = [][]driver.Value{{db.version, db.dirty}}
+		}
+		return rows, nil
+	default:
+		return nil, fmt.Errorf("fake: unexpected query %q", query)
+	}
+}
+
+type fakeMigrationRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeMigrationRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeMigrationRows) Close() error {
+	return nil
+}
+
+func (r *fakeMigrationRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func newFakeMigrator(t *testing.T, fake *fakeMigrationDB) *migrate.Migrator {
+	t.Helper()
+
+	fsys := fstest.MapFS{
+		"000001_create_a.up.sql":   {Data: []byte("CREATE TABLE a")},
+		"000001_create_a.down.sql": {Data: []byte("DROP TABLE a")},
+		"000002_create_b.up.sql":   {Data: []byte("CREATE TABLE b")},
+		"000002_create_b.down.sql": {Data: []byte("DROP TABLE b")},
+		"000005_create_c.up.sql":   {Data: []byte("CREATE TABLE c")},
+		"000005_create_c.down.sql": {Data: []byte("DROP TABLE c")},
+	}
+
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+
+	m, err := migrate.New(db, fsys)
+	assert.NilError(t, err)
+	return m
+}
+
+func TestMigrator(t *testing.T) {
+	tests := []struct {
+		name         string
+		start        int64
+		dirty        bool
+		failOn       string
+		run          func(m *migrate.Migrator) ([]migrate.Migration, error)
+		wantRan      []int64
+		wantExecuted []string
+		wantVersion  int64
+		wantErr      error
+		wantErrText  string
+	}{
+		{
+			name:         "up from empty",
+			run:          (*migrate.Migrator).Up,
+			wantRan:      []int64{1, 2, 5},
+			wantExecuted: []string{"CREATE TABLE a", "CREATE TABLE b", "CREATE TABLE c"},
+			wantVersion:  5,
+		},
+		{
+			name:         "up part way",
+			start:        2,
+			run:          (*migrate.Migrator).Up,
+			wantRan:      []int64{5},
+			wantExecuted: []string{"CREATE TABLE c"},
+			wantVersion:  5,
+		},
+		{
+			name:        "up to date",
+			start:       5,
+			run:         (*migrate.Migrator).Up,
+			wantVersion: 5,
+		},
+		{
+			name:         "down to the previous version",
+			start:        5,
+			run:          (*migrate.Migrator).Down,
+			wantRan:      []int64{5},
+			wantExecuted: []string{"DROP TABLE c"},
+			wantVersion:  2,
+		},
+		{
+			name:         "down from the first version",
+			start:        1,
+			run:          (*migrate.Migrator).Down,
+			wantRan:      []int64{1},
+			wantExecuted: []string{"DROP TABLE a"},
+			wantVersion:  0,
+		},
+		{
+			name:        "down from empty",
+			run:         (*migrate.Migrator).Down,
+			wantVersion: 0,
+		},
+		{
+			name:         "to an earlier version",
+			start:        5,
+			run:          func(m *migrate.Migrator) ([]migrate.Migration, error) { return m.To(1) },
+			wantRan:      []int64{5, 2},
+			wantExecuted: []string{"DROP TABLE c", "DROP TABLE b"},
+			wantVersion:  1,
+		},
+		{
+			name:         "to zero",
+			start:        5,
+			run:          func(m *migrate.Migrator) ([]migrate.Migration, error) { return m.To(0) },
+			wantRan:      []int64{5, 2, 1},
+			wantExecuted: []string{"DROP TABLE c", "DROP TABLE b", "DROP TABLE a"},
+			wantVersion:  0,
+		},
+		{
+			name:         "to a later version",
+			start:        1,
+			run:          func(m *migrate.Migrator) ([]migrate.Migration, error) { return m.To(2) },
+			wantRan:      []int64{2},
+			wantExecuted: []string{"CREATE TABLE b"},
+			wantVersion:  2,
+		},
+		{
+			name:        "to an unknown version",
+			start:       1,
+			run:         func(m *migrate.Migrator) ([]migrate.Migration, error) { return m.To(3) },
+			wantVersion: 1,
+			wantErr:     migrate.ErrUnknownVersion,
+		},
+		{
+			name:        "from an unknown version",
+			start:       3,
+			run:         (*migrate.Migrator).Up,
+			wantVersion: 3,
+			wantErr:     migrate.ErrUnknownVersion,
+		},
+		{
+			name:        "dirty",
+			start:       2,
+			dirty:       true,
+			run:         (*migrate.Migrator).Up,
+			wantVersion: 2,
+			wantErr:     migrate.ErrDirty,
+		},
+		{
+			name:         "failing migration",
+			failOn:       "CREATE TABLE b",
+			run:          (*migrate.Migrator).Up,
+			wantRan:      []int64{1},
+			wantExecuted: []string{"CREATE TABLE a"},
+			wantVersion:  1,
+			wantErrText:  "migrate: 2_create_b up: fake: syntax error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeMigrationDB{table: true, failOn: tt.failOn}
+			if tt.start != 0 {
+				fake.hasRow, fake.version, fake.dirty = true, tt.start, tt.dirty
+			}
+
+			ran, err := tt.run(newFakeMigrator(t, fake))
+			switch {
+			case tt.wantErr != nil:
+				assert.Equal(t, errors.Is(err, tt.wantErr), true)
+			case tt.wantErrText != "":
+				assert.Equal(t, err != nil, true)
+				assert.StringContains(t, err.Error(), tt.wantErrText)
+			default:
+				assert.NilError(t, err)
+			}
+
+			assert.Equal(t, len(ran), len(tt.wantRan))
+			for i := range tt.wantRan {
+				assert.Equal(t, ran[i].Version, tt.wantRan[i])
+			}
+
+			assert.Equal(t, len(fake.executed), len(tt.wantExecuted))
+			for i := range tt.wantExecuted {
+				assert.Equal(t, fake.executed[i], tt.wantExecuted[i])
+			}
+
+			assert.Equal(t, fake.version, tt.wantVersion)
+			assert.Equal(t, fake.hasRow, tt.wantVersion != 0)
+			assert.Equal(t, fake.locked, false)
+		})
+	}
+}
+
+func TestMigratorPeek(t *testing.T) {
+	tests := []struct {
+		name        string
+		table       bool
+		version     int64
+		dirty       bool
+		wantCurrent int64
+		wantPending []int64
+	}{
+		{"never migrated", false, 0, false, 0, []int64{1, 2, 5}},
+		{"empty table", true, 0, false, 0, []int64{1, 2, 5}},
+		{"part way", true, 2, false, 2, []int64{5}},
+		{"up to date", true, 5, false, 5, nil},
+		{"dirty", true, 2, true, 2, []int64{5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeMigrationDB{table: tt.table, hasRow: tt.version != 0, version: tt.version, dirty: tt.dirty}
+
+			status, err := newFakeMigrator(t, fake).Peek(context.Background())
+			assert.NilError(t, err)
+
+			assert.Equal(t, status.Current, tt.wantCurrent)
+			assert.Equal(t, status.Latest, int64(5))
+			assert.Equal(t, status.Dirty, tt.dirty)
+			assert.Equal(t, status.Behind(), len(tt.wantPending) > 0)
+			assert.Equal(t, len(status.Pending), len(tt.wantPending))
+			for i := range tt.wantPending {
+				assert.Equal(t, status.Pending[i].Version, tt.wantPending[i])
+			}
+
+			// Peek must leave the database alone.
+			assert.Equal(t, fake.table, tt.table)
+			assert.Equal(t, fake.locked, false)
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"greenlight.bcc/internal/data"
+)
+
+// The mock models in internal/data return fixed values. The handler tests
+// need more than that: particular emails, tokens and IDs that fail in a
+// particular way. testModels wraps the mocks with those fixtures.
+
+var errMock = errors.New("mock error")
+
+func testModels() data.Models {
+	models := data.NewMockModels()
+	models.Movies = testMovieModel{}
+	models.Users = testUserModel{}
+	models.Tokens = testTokenModel{}
+	models.Permissions = testPermissionModel{}
+	return models
+}
+
+// testMovieModel has movies 1, 12 and 13, all at version 1. Movie 12 is
+// always edited concurrently, and writes to movie 13 fail. Reading movie 11
+// fails, and reading movie 404 panics.
+type testMovieModel struct {
+	data.MockMovieModel
+}
+
+func (m testMovieModel) Insert(movie *data.Movie) error {
+	if movie.Title == "error" {
+		return errMock
+	}
+	movie.ID = 2
+	movie.CreatedAt = time.Now()
+	movie.Version = 1
+	return nil
+}
+
+func (m testMovieModel) Get(id int64) (*data.Movie, error) {
+	switch id {
+	case 1, 12, 13:
+		return &data.Movie{
+			ID:        id,
+			CreatedAt: time.Now(),
+			Year:      2023,
+			Runtime:   105,
+			Title:     "Test Mock",
+			Genres:    []string{""},
+			Version:   1,
+		}, nil
+	case 11:
+		return nil, errMock
+	case 404:
+		panic("mock panic")
+	default:
+		return nil, data.ErrRecordNotFound
+	}
+}
+
+func (m testMovieModel) Update(movie *data.Movie) error {
+	switch movie.ID {
+	case 12:
+		return data.ErrEditConflict
+	case 13:
+		return errMock
+	}
+	movie.Version++
+	return nil
+}
+
+func (m testMovieModel) Delete(id int64, version int32) error {
+	switch id {
+	case 1:
+		return nil
+	case 12:
+		if version != 0 {
+			return data.ErrEditConflict
+		}
+		return nil
+	case 13:
+		return errMock
+	default:
+		return data.ErrRecordNotFound
+	}
+}
+
+func (m testMovieModel) GetAll(movieFilters data.MovieFilters, filters data.Filters) ([]*data.Movie, data.Metadata, error) {
+	if movieFilters.Title == "error" {
+		return nil, data.Metadata{}, errMock
+	}
+
+	movies := []*data.Movie{}
+	for id := int64(1); id <= 2; id++ {
+		movie := &data.Movie{
+			ID:        id,
+			CreatedAt: time.Now(),
+			Year:      2023,
+			Runtime:   105,
+			Title:     "Test Mock",
+			Genres:    []string{"comedy", "drama"},
+			Version:   1,
+		}
+		if movieFilters.Title != "" {
+			movie.Highlight = "<mark>Test</mark> Mock"
+		}
+		movies = append(movies, movie)
+	}
+
+	metadata := data.Metadata{
+		CurrentPage:  filters.Page,
+		PageSize:     filters.PageSize,
+		FirstPage:    1,
+		LastPage:     (len(movies) + filters.PageSize - 1) / filters.PageSize,
+		TotalRecords: len(movies),
+	}
+
+	return movies, metadata, nil
+}
+
+// testUserModel signs everyone in as user 1, the user with every movie
+// permission, unless the email or token names another fixture.
+type testUserModel struct {
+	data.MockUserModel
+}
+
+func (m testUserModel) Insert(user *data.User) error {
+	switch user.Email {
+	case "exists@test.com":
+		return data.ErrDuplicateEmail
+	case "errorInsert@test.com":
+		return errMock
+	case "errorPermissions@test.com":
+		user.ID = 2
+	case "errorTokens@test.com":
+		user.ID = 3
+	default:
+		user.ID = 1
+	}
+	user.CreatedAt = time.Now()
+	user.Version = 1
+	return nil
+}
+
+func (m testUserModel) Get(id int64) (*data.User, error) {
+	switch id {
+	case 1, 2:
+		return testUser(id, true), nil
+	default:
+		return nil, data.ErrRecordNotFound
+	}
+}
+
+func (m testUserModel) GetByEmail(email string) (*data.User, error) {
+	switch email {
+	case "notFound@test.com":
+		return nil, data.ErrRecordNotFound
+	case "error@test.com":
+		return nil, errMock
+	}
+
+	user := testUser(1, true)
+	if email == "errorToken@test.com" {
+		user.ID = 3
+	}
+
+	plaintext := "pa$$word"
+	if email == "notMatch@test.com" {
+		plaintext = "different"
+	}
+	err := user.Password.Set(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (m testUserModel) Update(user *data.User) error {
+	switch user.ID {
+	case 4:
+		return data.ErrEditConflict
+	case 5:
+		return errMock
+	}
+	user.Version++
+	return nil
+}
+
+// GetForToken maps each fixture token, 26 copies of one letter, to a user
+// whose later reads or writes fail in a known way.
+func (m testUserModel) GetForToken(tokenScope, tokenPlaintext string) (*data.User, error) {
+	switch tokenPlaintext {
+	case strings.Repeat("b", 26):
+		return nil, data.ErrRecordNotFound
+	case strings.Repeat("c", 26):
+		return nil, errMock
+	case strings.Repeat("d", 26):
+		return testUser(4, true), nil
+	case strings.Repeat("e", 26):
+		return testUser(5, true), nil
+	case strings.Repeat("f", 26):
+		return testUser(6, true), nil
+	case strings.Repeat("g", 26):
+		return testUser(7, false), nil
+	case strings.Repeat("h", 26):
+		return testUser(8, true), nil
+	case strings.Repeat("k", 26):
+		return testUser(9, true), nil
+	case strings.Repeat("r", 26):
+		return testUser(10, true), nil
+	default:
+		return testUser(1, true), nil
+	}
+}
+
+func testUser(id int64, activated bool) *data.User {
+	return &data.User{
+		ID:        id,
+		CreatedAt: time.Now(),
+		Name:      "Test",
+		Email:     "test@test.com",
+		Activated: activated,
+		Version:   1,
+	}
+}
+
+// testTokenModel fails to create tokens for user 3 and to delete them for
+// user 6.
+type testTokenModel struct {
+	data.MockTokenModel
+}
+
+func (m testTokenModel) New(userID int64, ttl time.Duration, scope string) (*data.Token, error) {
+	if userID == 3 {
+		return nil, errMock
+	}
+	return &data.Token{
+		Plaintext: strings.Repeat("a", 26),
+		UserID:    userID,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+	}, nil
+}
+
+func (m testTokenModel) DeleteAllForUser(scope string, userID int64) error {
+	if userID == 6 {
+		return errMock
+	}
+	return nil
+}
+
+// testPermissionModel fails for user 8, gives user 9 no permissions and
+// user 10 only movies:read. Everyone else has every movie permission.
+type testPermissionModel struct {
+	data.MockPermissionModel
+}
+
+func (m testPermissionModel) GetAllForUser(userID int64) (data.Permissions, error) {
+	switch userID {
+	case 8:
+		return nil, errMock
+	case 9:
+		return data.Permissions{}, nil
+	case 10:
+		return data.Permissions{"movies:read"}, nil
+	default:
+		return data.Permissions{"movies:read", "movies:write", "movies:propose", "movies:approve"}, nil
+	}
+}
+
+func (m testPermissionModel) AddForUser(userID int64, codes ...string) error {
+	if userID == 2 {
+		return errMock
+	}
+	return nil
+}
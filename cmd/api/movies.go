@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/jsonpatch"
 	"greenlight.bcc/internal/validator"
 )
 
@@ -33,20 +35,23 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	v := validator.New()
 
 	if data.ValidateMovie(v, &movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.Movies.Insert(&movie)
+	err = app.modelsFor(r).Movies.Insert(&movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	app.suggestions.Set(movie.ID, movie.Title, movie.Genres)
+	app.refreshSimilarities(movie.ID)
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(&movie))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -60,7 +65,22 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	v := validator.New()
+	qs := r.URL.Query()
+
+	fields := app.readCSV(qs, "fields", []string{})
+	include := app.readIncludes(qs, v)
+
+	if data.ValidateFields(v, fields, movieFieldSafelist); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	if !app.authorizeIncludes(w, r, include) {
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -71,7 +91,36 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// Included data can change without the movie's version changing, so
+	// those responses are tagged by their content, as lists are.
+	enc, _ := negotiateEncoder(r.Header.Get("Accept"), encoders)
+	etag := movieVariantETag(movie, enc.contentType, fields)
+	if len(include) == 0 && app.notModified(w, r, etag) {
+		return
+	}
+
+	rendered, err := app.renderMovies(r, []*data.Movie{movie}, fields, include)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"movie": rendered[0]}
+	if len(include) > 0 {
+		etag, err = weakETag(envelope{"content_type": enc.contentType, "movie": rendered[0]})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if app.notModified(w, r, etag) {
+			return
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeResponse(w, r, http.StatusOK, env, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -84,7 +133,11 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	if !app.requireIfMatch(w, r) {
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -94,38 +147,59 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
 
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	if !app.checkIfMatch(w, r, movie) {
 		return
 	}
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+
+	switch requestMediaType(r) {
+	case mergePatchMediaType, jsonPatchMediaType:
+		err = app.readMoviePatch(w, r, movie)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				app.patchTestFailedResponse(w, r, err)
+			case errors.Is(err, jsonpatch.ErrPathNotFound), errors.Is(err, errPatchResult):
+				app.invalidPatchResponse(w, r, err)
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+	default:
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
+
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+		if input.Genres != nil {
+			movie.Genres = input.Genres
+		}
 	}
 
 	v := validator.New()
 	if data.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.modelsFor(r).Movies.Update(movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -136,8 +210,13 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 
 		return
 	}
+	app.suggestions.Set(movie.ID, movie.Title, movie.Genres)
+	app.refreshSimilarities(movie.ID)
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -150,18 +229,49 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	if !app.requireIfMatch(w, r) {
+		return
+	}
+
+	// The current version is only needed to evaluate If-Match, so plain
+	// deletes still go straight to the database. A conditional delete passes
+	// the matched version on, so a change made since it was read fails the
+	// precondition rather than being deleted.
+	var version int32
+	if r.Header.Get("If-Match") != "" {
+		movie, err := app.modelsFor(r).Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !app.checkIfMatch(w, r, movie) {
+			return
+		}
+		version = movie.Version
+	}
+
+	err = app.modelsFor(r).Movies.Delete(id, version)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
+	app.suggestions.Delete(id)
+	app.refreshSimilarities(id)
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -169,35 +279,99 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilters
+		Include []string
+		Facets  []string
 		data.Filters
 	}
 
 	v := validator.New()
 	qs := r.URL.Query()
 
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.MovieFilters = app.readMovieFilters(qs, v)
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.Fields = app.readCSV(qs, "fields", []string{})
+	input.Include = app.readIncludes(qs, v)
+	input.Facets = app.readCSV(qs, "facets", []string{})
+
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime", data.SortRelevance}
+	input.Filters.FieldSafelist = movieFieldSafelist
+
+	data.ValidateMovieFilters(v, input.MovieFilters)
+	v.CheckCode(input.Filters.Sort != data.SortRelevance || input.Title != "", "sort", "not_permitted", "relevance sort needs a title to search for")
+	for _, facet := range input.Facets {
+		v.CheckCode(validator.PermittedValue(facet, data.FacetGenres, data.FacetDecade), "facets", "not_permitted", "invalid facets value")
+	}
+	v.CheckCode(validator.Unique(input.Facets), "facets", "duplicate", "must not contain duplicate values")
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.failedValidationResponse(w, r, v)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	if !app.authorizeIncludes(w, r, input.Include) {
+		return
+	}
+
+	movies, metadata, err := app.modelsFor(r).Movies.GetAll(input.MovieFilters, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	rendered, err := app.renderMovies(r, movies, input.Filters.Fields, input.Include)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"movies": rendered, "metadata": metadata}
+
+	if len(input.Facets) > 0 {
+		facets, err := app.modelsFor(r).Movies.Facets(input.MovieFilters, input.Facets)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		env["facets"] = facets
+	}
+
+	// The tag covers the negotiated format too, since the same list in
+	// another format is a different representation.
+	enc, _ := negotiateEncoder(r.Header.Get("Accept"), encoders)
+	etag, err := weakETag(envelope{"content_type": enc.contentType, "response": env})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if app.notModified(w, r, etag) {
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeResponse(w, r, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readMovieFilters reads the filters shared by the movie list and export
+// endpoints. Bounds are given as year_gte=1990, runtime_lt=120,
+// created_at_gte=2023-01-01T00:00:00Z and so on.
+func (app *application) readMovieFilters(qs url.Values, v *validator.Validator) data.MovieFilters {
+	return data.MovieFilters{
+		Title:         app.readString(qs, "title", ""),
+		Fuzzy:         app.readBool(qs, "fuzzy", false, v),
+		Genres:        app.readCSV(qs, "genres", []string{}),
+		GenresAny:     app.readCSV(qs, "genres_any", []string{}),
+		ExcludeGenres: app.readCSV(qs, "exclude_genres", []string{}),
+		Year:          app.readIntRange(qs, "year", v),
+		Runtime:       app.readIntRange(qs, "runtime", v),
+		CreatedAt:     app.readTimeRange(qs, "created_at", v),
 	}
 }
@@ -397,3 +397,201 @@ func TestListMovie(t *testing.T) {
 		})
 	}
 }
+
+func TestListMovieRangeFilters(t *testing.T) {
+	app := newTestApplication(t, false)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid ranges",
+			urlPath:  "/v1/movies?year_gte=1990&year_lt=2000&runtime_gt=60&runtime_lte=120",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Valid created_at range",
+			urlPath:  "/v1/movies?created_at_gte=2023-01-01T00:00:00Z&created_at_lt=2024-01-01T00:00:00Z",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Valid genre filters",
+			urlPath:  "/v1/movies?genres_any=comedy,drama&exclude_genres=horror",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Non-integer bound",
+			urlPath:  "/v1/movies?year_gte=nineties",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"year_gte":"must be an integer value"`,
+		},
+		{
+			name:     "Non-positive bound",
+			urlPath:  "/v1/movies?runtime_lt=0",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"runtime_lt":"must be a positive integer"`,
+		},
+		{
+			name:     "Both gt and gte",
+			urlPath:  "/v1/movies?year_gt=1990&year_gte=1991",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"year_gte"`,
+		},
+		{
+			name:     "Inverted range",
+			urlPath:  "/v1/movies?runtime_gte=120&runtime_lte=90",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"runtime":"lower bound must not be greater than upper bound"`,
+		},
+		{
+			name:     "Non-valid timestamp",
+			urlPath:  "/v1/movies?created_at_lt=yesterday",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"created_at_lt":"must be an RFC 3339 timestamp"`,
+		},
+		{
+			name:     "Inverted created_at range",
+			urlPath:  "/v1/movies?created_at_gt=2024-01-01T00:00:00Z&created_at_lte=2023-01-01T00:00:00Z",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"created_at"`,
+		},
+		{
+			name:     "Duplicate excluded genres",
+			urlPath:  "/v1/movies?exclude_genres=horror,horror",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"exclude_genres"`,
+		},
+		{
+			name:     "Export validates filters",
+			urlPath:  "/v1/movies/export?year_lte=-5",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestSearchMovies(t *testing.T) {
+	app := newTestApplication(t, false)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Relevance sort",
+			urlPath:  "/v1/movies?title=tes&sort=relevance",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Relevance sort without title",
+			urlPath:  "/v1/movies?sort=relevance",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"sort":"relevance sort needs a title to search for"`,
+		},
+		{
+			name:     "Descending relevance sort",
+			urlPath:  "/v1/movies?title=tes&sort=-relevance",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Highlighted matches",
+			urlPath:  "/v1/movies?title=tes",
+			wantCode: http.StatusOK,
+			wantBody: `"highlight":"\u003cmark\u003eTest\u003c/mark\u003e Mock"`,
+		},
+		{
+			name:     "Fuzzy search",
+			urlPath:  "/v1/movies?title=tset&fuzzy=true",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Invalid fuzzy",
+			urlPath:  "/v1/movies?title=tset&fuzzy=maybe",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"fuzzy":"must be a boolean value"`,
+		},
+		{
+			name:     "Highlight only",
+			urlPath:  "/v1/movies?title=tes&fields=highlight",
+			wantCode: http.StatusOK,
+			wantBody: `"movies":[{"highlight":`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestListMovieFacets(t *testing.T) {
+	app := newTestApplication(t, false)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Genres and decade",
+			urlPath:  "/v1/movies?genres=comedy&facets=genres,decade",
+			wantCode: http.StatusOK,
+			wantBody: `"facets":{"decade":[{"value":"2020s","count":2}],"genres":[{"value":"comedy","count":2},{"value":"drama","count":2}]}`,
+		},
+		{
+			name:     "Single facet",
+			urlPath:  "/v1/movies?facets=decade",
+			wantCode: http.StatusOK,
+			wantBody: `"facets":{"decade":[`,
+		},
+		{
+			name:     "Unknown facet",
+			urlPath:  "/v1/movies?facets=director",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: `"facets":"invalid facets value"`,
+		},
+		{
+			name:     "Duplicate facet",
+			urlPath:  "/v1/movies?facets=genres,genres",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Error while counting",
+			urlPath:  "/v1/movies?title=facets&facets=genres",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
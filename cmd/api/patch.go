@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/jsonpatch"
+)
+
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// errPatchResult is returned when a patch applies cleanly but leaves the
+// movie in a shape that can't be decoded, such as a runtime that isn't a
+// "<n> mins" string.
+var errPatchResult = errors.New("patched movie is not valid")
+
+// patchableMovie is the document that patches are applied to. Unlike
+// data.Movie it has no omitempty fields, so every editable member exists
+// and can be tested, replaced or removed.
+type patchableMovie struct {
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+}
+
+func requestMediaType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType
+}
+
+// readMoviePatch applies a JSON Merge Patch or JSON Patch body to movie's
+// editable fields. The caller still has to validate the result.
+func (app *application) readMoviePatch(w http.ResponseWriter, r *http.Request, movie *data.Movie) error {
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		return err
+	}
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return errors.New("body must not be empty")
+	}
+
+	doc, err := json.Marshal(patchableMovie{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch requestMediaType(r) {
+	case mergePatchMediaType:
+		doc, err = jsonpatch.MergePatch(doc, patch)
+	default:
+		doc, err = jsonpatch.Apply(doc, patch)
+	}
+	if err != nil {
+		return err
+	}
+
+	var patched patchableMovie
+
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&patched)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errPatchResult, err)
+	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = patched.Runtime
+	movie.Genres = patched.Genres
+
+	return nil
+}
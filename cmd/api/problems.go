@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"greenlight.bcc/internal/validator"
+)
+
+const problemTypeBase = "urn:greenlight:problem:"
+
+// problemEncoder is only offered for error responses, so asking for
+// application/problem+json changes the shape of errors without affecting
+// successful responses.
+var problemEncoder = encoder{
+	contentType: "application/problem+json",
+	mediaTypes:  []string{"application/problem+json"},
+	encode:      encodeJSON,
+}
+
+var errorEncoders = append(append([]encoder{}, encoders...), problemEncoder)
+
+type problemFieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// problem builds an RFC 7807 problem document. Validation errors are listed
+// field by field, each with the code given by the check that failed.
+func (app *application) problem(r *http.Request, status int, code string, message any) envelope {
+	problem := envelope{
+		"type":       problemTypeBase + code,
+		"title":      http.StatusText(status),
+		"status":     status,
+		"code":       code,
+		"instance":   r.URL.Path,
+		"request_id": app.contextGetRequestID(r),
+	}
+
+	switch message := message.(type) {
+	case *validator.Validator:
+		problem["detail"] = "one or more fields failed validation"
+
+		fieldErrors := make([]problemFieldError, 0, len(message.Errors))
+		for field, msg := range message.Errors {
+			fieldErrors = append(fieldErrors, problemFieldError{Field: field, Code: message.Codes[field], Message: msg})
+		}
+		sort.Slice(fieldErrors, func(i, j int) bool {
+			return fieldErrors[i].Field < fieldErrors[j].Field
+		})
+
+		problem["errors"] = fieldErrors
+	default:
+		problem["detail"] = message
+	}
+
+	return problem
+}
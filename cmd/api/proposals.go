@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/validator"
+)
+
+func (app *application) createProposalHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MovieID int64         `json:"movie_id"`
+		Title   *string       `json:"title"`
+		Year    *int32        `json:"year"`
+		Runtime *data.Runtime `json:"runtime"`
+		Genres  []string      `json:"genres"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	proposal := &data.Proposal{
+		UserID:  app.contextGetUser(r).ID,
+		MovieID: input.MovieID,
+	}
+
+	if input.MovieID != 0 {
+		movie, err := app.modelsFor(r).Movies.Get(input.MovieID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v := validator.New()
+				v.AddErrorCode("movie_id", "not_found", "must refer to an existing movie")
+				app.failedValidationResponse(w, r, v)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		proposal.Movie = *movie
+		proposal.BaseVersion = movie.Version
+	}
+
+	if input.Title != nil {
+		proposal.Movie.Title = *input.Title
+	}
+	if input.Year != nil {
+		proposal.Movie.Year = *input.Year
+	}
+	if input.Runtime != nil {
+		proposal.Movie.Runtime = *input.Runtime
+	}
+	if input.Genres != nil {
+		proposal.Movie.Genres = input.Genres
+	}
+
+	v := validator.New()
+	if data.ValidateMovie(v, &proposal.Movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	err = app.modelsFor(r).Proposals.Insert(proposal)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/proposals/%d", proposal.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"proposal": proposal}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showProposalHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	proposal, err := app.modelsFor(r).Proposals.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"proposal": proposal}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listProposalsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", data.ProposalPending)
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "created_at"
+
+	input.Filters.SortSafelist = []string{"created_at"}
+
+	v.CheckCode(validator.PermittedValue(input.Status, data.ProposalPending, data.ProposalApproved, data.ProposalRejected), "status", "not_permitted", "invalid status value")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	proposals, metadata, err := app.modelsFor(r).Proposals.GetAll(input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"proposals": proposals, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) approveProposalHandler(w http.ResponseWriter, r *http.Request) {
+	app.reviewProposal(w, r, data.ProposalApproved)
+}
+
+func (app *application) rejectProposalHandler(w http.ResponseWriter, r *http.Request) {
+	app.reviewProposal(w, r, data.ProposalRejected)
+}
+
+// reviewProposal records the reviewer's decision. The review and, for an
+// approval, the movie write happen in one transaction, so an edit whose base
+// version is stale is refused with an edit conflict and stays pending, as
+// does an edit of a movie that has since been deleted. Reviewers can't
+// review their own proposals.
+func (app *application) reviewProposal(w http.ResponseWriter, r *http.Request, status string) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Comment string `json:"comment"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateProposalReview(v, input.Comment); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	proposal, err := app.modelsFor(r).Proposals.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reviewer := app.contextGetUser(r)
+
+	if proposal.UserID == reviewer.ID {
+		app.selfReviewResponse(w, r)
+		return
+	}
+
+	if proposal.Status != data.ProposalPending {
+		app.proposalAlreadyReviewedResponse(w, r)
+		return
+	}
+
+	reviewedAt := time.Now()
+	proposal.Status = status
+	proposal.ReviewerID = reviewer.ID
+	proposal.Comment = input.Comment
+	proposal.ReviewedAt = &reviewedAt
+
+	err = app.modelsFor(r).Proposals.Review(proposal)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrMovieDeleted):
+			app.proposalMovieDeletedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if status == data.ProposalApproved {
+		app.suggestions.Set(proposal.Movie.ID, proposal.Movie.Title, proposal.Movie.Genres)
+		app.refreshSimilarities(proposal.Movie.ID)
+	}
+
+	ctx := app.backgroundContext(r)
+	app.background(func() {
+		user, err := app.models.Traced(ctx, app.tracer).Users.Get(proposal.UserID)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		data := map[string]any{
+			"proposalID": proposal.ID,
+			"title":      proposal.Movie.Title,
+			"status":     proposal.Status,
+			"comment":    proposal.Comment,
+		}
+
+		err = app.sendEmail(ctx, user.Email, "proposal_reviewed.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"proposal": proposal}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
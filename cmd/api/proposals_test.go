@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"greenlight.bcc/internal/assert"
+)
+
+func TestCreateProposal(t *testing.T) {
+	app := newTestApplication(t, false)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	const (
+		validTitle   = "Test Title"
+		validYear    = 2021
+		validRuntime = "105 mins"
+	)
+
+	validGenres := []string{"comedy", "drama"}
+
+	tests := []struct {
+		name     string
+		MovieID  int64
+		Title    string
+		Year     int32
+		Runtime  string
+		Genres   []string
+		wantCode int
+	}{
+		{
+			name:     "valid new movie",
+			Title:    validTitle,
+			Year:     validYear,
+			Runtime:  validRuntime,
+			Genres:   validGenres,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "valid edit",
+			MovieID:  1,
+			Title:    validTitle,
+			Year:     validYear,
+			Runtime:  validRuntime,
+			Genres:   validGenres,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "edit of non-existent movie",
+			MovieID:  100,
+			Title:    validTitle,
+			Year:     validYear,
+			Runtime:  validRuntime,
+			Genres:   validGenres,
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "error while retrieving movie",
+			MovieID:  11,
+			Title:    validTitle,
+			Year:     validYear,
+			Runtime:  validRuntime,
+			Genres:   validGenres,
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "year < 1888",
+			Title:    validTitle,
+			Year:     1500,
+			Runtime:  validRuntime,
+			Genres:   validGenres,
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "error while inserting",
+			Title:    "error",
+			Year:     validYear,
+			Runtime:  validRuntime,
+			Genres:   validGenres,
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "test for wrong input",
+			Title:    validTitle,
+			Year:     validYear,
+			Runtime:  validRuntime,
+			Genres:   validGenres,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputData := struct {
+				MovieID int64    `json:"movie_id,omitempty"`
+				Title   string   `json:"title"`
+				Year    int32    `json:"year"`
+				Runtime string   `json:"runtime"`
+				Genres  []string `json:"genres"`
+			}{
+				MovieID: tt.MovieID,
+				Title:   tt.Title,
+				Year:    tt.Year,
+				Runtime: tt.Runtime,
+				Genres:  tt.Genres,
+			}
+
+			b, err := json.Marshal(&inputData)
+			if err != nil {
+				t.Fatal("wrong input data")
+			}
+
+			if tt.name == "test for wrong input" {
+				b = append(b, 'a')
+			}
+
+			code, _, _ := ts.postForm(t, "/v1/proposals", b)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestListProposals(t *testing.T) {
+	app := newTestApplication(t, false)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{
+			name:     "default status",
+			urlPath:  "/v1/proposals",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "rejected status",
+			urlPath:  "/v1/proposals?status=rejected&page=1&page_size=10",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "invalid status",
+			urlPath:  "/v1/proposals?status=unknown",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "invalid page size",
+			urlPath:  "/v1/proposals?page_size=101",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "show existing proposal",
+			urlPath:  "/v1/proposals/1",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "show non-existent proposal",
+			urlPath:  "/v1/proposals/100",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error while retrieving proposal",
+			urlPath:  "/v1/proposals/11",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestReviewProposal(t *testing.T) {
+	app := newTestApplication(t, false)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "approve new movie",
+			urlPath:  "/v1/proposals/1/approve",
+			body:     `{"comment": "looks good"}`,
+			wantCode: http.StatusOK,
+			wantBody: `"status":"approved"`,
+		},
+		{
+			name:     "approve edit",
+			urlPath:  "/v1/proposals/2/approve",
+			body:     `{}`,
+			wantCode: http.StatusOK,
+			wantBody: `"movie_id":1`,
+		},
+		{
+			name:     "reject",
+			urlPath:  "/v1/proposals/4/reject",
+			body:     `{"comment": "duplicate"}`,
+			wantCode: http.StatusOK,
+			wantBody: `"status":"rejected"`,
+		},
+		{
+			name:     "already reviewed",
+			urlPath:  "/v1/proposals/3/approve",
+			body:     `{}`,
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "stale base version",
+			urlPath:  "/v1/proposals/4/approve",
+			body:     `{}`,
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "concurrent review",
+			urlPath:  "/v1/proposals/5/reject",
+			body:     `{}`,
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:     "own proposal",
+			urlPath:  "/v1/proposals/6/approve",
+			body:     `{}`,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "movie deleted since",
+			urlPath:  "/v1/proposals/7/approve",
+			body:     `{}`,
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "has been deleted",
+		},
+		{
+			name:     "non-existent proposal",
+			urlPath:  "/v1/proposals/100/approve",
+			body:     `{}`,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error while retrieving proposal",
+			urlPath:  "/v1/proposals/11/reject",
+			body:     `{}`,
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "test for wrong input",
+			urlPath:  "/v1/proposals/1/approve",
+			body:     `{"comment": 1}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.postForm(t, tt.urlPath, []byte(tt.body))
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// liveConfig returns the configuration that the request path should use for
+// settings that can be reloaded without a restart. Until the first reload it
+// is app.config.
+func (app *application) liveConfig() *config {
+	if cfg := app.live.Load(); cfg != nil {
+		return cfg
+	}
+	return &app.config
+}
+
+// reloadableSettings renders the settings that a reload applies, keyed by
+// their flag names.
+func reloadableSettings(cfg *config) map[string]string {
+	return map[string]string{
+		"limiter-rps":           strconv.FormatFloat(cfg.limiter.rps, 'f', -1, 64),
+		"limiter-burst":         strconv.Itoa(cfg.limiter.burst),
+		"limiter-enabled":       strconv.FormatBool(cfg.limiter.enabled),
+		"cors-trusted-origins":  strings.Join(cfg.cors.trustedOrigins, " "),
+		"log-level":             cfg.logLevel.String(),
+		"log-stack-level":       cfg.logStackLevel.String(),
+		"log-sample-tick":       cfg.logs.sampleTick.String(),
+		"log-sample-first":      strconv.Itoa(cfg.logs.sampleFirst),
+		"log-sample-thereafter": strconv.Itoa(cfg.logs.sampleThereafter),
+	}
+}
+
+// reload swaps in the rate limiter, CORS, log level and log sampling
+// settings from next, which must already have been validated. Anything else
+// that differs from the running configuration is left alone and only
+// logged, because it needs a restart to take effect.
+func (app *application) reload(next config) {
+	current := app.liveConfig()
+
+	updated := *current
+	updated.limiter = next.limiter
+	updated.cors = next.cors
+	updated.logLevel = next.logLevel
+	updated.logStackLevel = next.logStackLevel
+	updated.logs.sampleTick = next.logs.sampleTick
+	updated.logs.sampleFirst = next.logs.sampleFirst
+	updated.logs.sampleThereafter = next.logs.sampleThereafter
+
+	before := reloadableSettings(current)
+	after := reloadableSettings(&updated)
+
+	changes := make(map[string]string)
+	for name, value := range after {
+		if before[name] != value {
+			changes[name] = "from " + strconv.Quote(before[name]) + " to " + strconv.Quote(value)
+		}
+	}
+
+	applyLogSettings(app.logger, &updated)
+	app.live.Store(&updated)
+
+	if len(changes) == 0 {
+		app.logger.PrintInfo("configuration reloaded with no changes", nil)
+	} else {
+		app.logger.PrintInfo("configuration reloaded", changes)
+	}
+
+	// Compare everything else by copying the reloadable settings across so
+	// that only the differences that were ignored remain.
+	next.limiter = updated.limiter
+	next.cors = updated.cors
+	next.logLevel = updated.logLevel
+	next.logStackLevel = updated.logStackLevel
+	next.logs.sampleTick = updated.logs.sampleTick
+	next.logs.sampleFirst = updated.logs.sampleFirst
+	next.logs.sampleThereafter = updated.logs.sampleThereafter
+	if !reflect.DeepEqual(next, updated) {
+		app.logger.PrintInfo("configuration changes other than the rate limiter, CORS, log levels and log sampling need a restart", nil)
+	}
+}
+
+// handleReloads re-reads the configuration every time the process receives
+// SIGHUP. A configuration that fails to load or validate is logged and
+// discarded, and the server carries on with what it had.
+func (app *application) handleReloads() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		next, _, err := loadConfig(os.Args[1:], os.LookupEnv)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{
+				"reload": "rejected",
+			})
+			continue
+		}
+
+		app.reload(next)
+	}
+}
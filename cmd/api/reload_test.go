@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"greenlight.bcc/internal/assert"
+	"greenlight.bcc/internal/jsonlog"
+)
+
+func TestReload(t *testing.T) {
+	t.Run("rate limiter", func(t *testing.T) {
+		app := newTestApplication(t, true)
+
+		handler := app.rateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		send := func() int {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+			return rr.Code
+		}
+
+		assert.Equal(t, send(), http.StatusOK)
+
+		next := app.config
+		next.limiter.burst = 1
+		next.limiter.rps = 0.001
+		app.reload(next)
+
+		assert.Equal(t, send(), http.StatusOK)
+		assert.Equal(t, send(), http.StatusTooManyRequests)
+
+		next.limiter.enabled = false
+		app.reload(next)
+
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, send(), http.StatusOK)
+		}
+	})
+
+	t.Run("trusted origins", func(t *testing.T) {
+		app := newTestApplication(t, false)
+
+		handler := app.enableCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		allowedOrigin := func(origin string) string {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Origin", origin)
+			handler.ServeHTTP(rr, r)
+			return rr.Header().Get("Access-Control-Allow-Origin")
+		}
+
+		assert.Equal(t, allowedOrigin("https://localhost:8000"), "https://localhost:8000")
+		assert.Equal(t, allowedOrigin("https://example.com"), "")
+
+		next := app.config
+		next.cors.trustedOrigins = []string{"https://example.com"}
+		app.reload(next)
+
+		assert.Equal(t, allowedOrigin("https://localhost:8000"), "")
+		assert.Equal(t, allowedOrigin("https://example.com"), "https://example.com")
+	})
+
+	t.Run("log level and changes", func(t *testing.T) {
+		app := newTestApplication(t, false)
+
+		var buf bytes.Buffer
+		app.logger = jsonlog.New(&buf, jsonlog.LevelInfo)
+
+		next := app.config
+		next.limiter.rps = 10
+		next.port = 9000
+		app.reload(next)
+
+		assert.StringContains(t, buf.String(), `"message":"configuration reloaded"`)
+		assert.StringContains(t, buf.String(), `"limiter-rps":"from \"0\" to \"10\""`)
+		assert.StringContains(t, buf.String(), "need a restart")
+		assert.Equal(t, app.liveConfig().port, app.config.port)
+
+		buf.Reset()
+		next.logLevel = jsonlog.LevelError
+		app.reload(next)
+		app.logger.PrintInfo("hidden", nil)
+
+		assert.Equal(t, app.logger.Level(), jsonlog.LevelError)
+		assert.Equal(t, buf.String(), "")
+	})
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
-	"expvar"
 	"net/http"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -14,20 +14,64 @@ func (app *application) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	patterns := app.newRoutePatterns()
+	handle := func(method, pattern string, h http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, app.tracedHandler(pattern, h))
+		patterns.add(method, pattern)
+	}
 
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	handle(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
+	handle(http.MethodGet, "/livez", app.livezHandler)
+	handle(http.MethodGet, "/readyz", app.readyzHandler)
 
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	handle(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
+	handle(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.idempotent(app.createMovieHandler)))
+	handle(http.MethodPost, "/v1/movies/import", app.requirePermission("movies:write", app.importMoviesHandler))
+	handle(http.MethodGet, "/v1/movies/:id", app.staticSegments(map[string]http.HandlerFunc{
+		"export":  app.requirePermission("movies:read", app.exportMoviesHandler),
+		"suggest": app.requirePermission("movies:read", app.suggestMoviesHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
+	handle(http.MethodGet, "/v1/movies/:id/similar", app.requirePermission("movies:read", app.similarMoviesHandler))
+	handle(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	handle(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
 
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	handle(http.MethodGet, "/v1/stats/movies", app.requirePermission("movies:read", app.movieStatsHandler))
 
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	handle(http.MethodGet, "/v1/imports/:id", app.requirePermission("movies:write", app.showImportHandler))
 
-	return app.metrics(app.recoverPanic(app.rateLimit(app.enableCORS(app.authenticate(router)))))
+	handle(http.MethodGet, "/v1/proposals", app.requirePermission("movies:approve", app.listProposalsHandler))
+	handle(http.MethodPost, "/v1/proposals", app.requirePermission("movies:propose", app.createProposalHandler))
+	handle(http.MethodGet, "/v1/proposals/:id", app.requirePermission("movies:approve", app.showProposalHandler))
+	handle(http.MethodPost, "/v1/proposals/:id/approve", app.requirePermission("movies:approve", app.approveProposalHandler))
+	handle(http.MethodPost, "/v1/proposals/:id/reject", app.requirePermission("movies:approve", app.rejectProposalHandler))
+
+	handle(http.MethodPost, "/v1/users", app.idempotent(app.registerUserHandler))
+	handle(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+
+	handle(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+
+	var h http.Handler = router
+	h = app.traced("authenticate", app.authenticate(h))
+	h = app.traced("enableCORS", app.enableCORS(h))
+	h = app.traced("rateLimit", app.rateLimit(h))
+	h = app.traced("recoverPanic", app.recoverPanic(h))
+	h = app.traced("requestID", app.requestID(h))
+	h = app.tracing(h)
+
+	return app.metrics(patterns, h)
+}
+
+// staticSegments dispatches fixed paths such as /v1/movies/export, which
+// httprouter refuses to register alongside the /v1/movies/:id wildcard, on
+// the value of the id parameter.
+func (app *application) staticSegments(routes map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		if h, ok := routes[params.ByName("id")]; ok {
+			app.setRoutePattern(r, strings.Replace(app.routePattern(r), ":id", params.ByName("id"), 1))
+			h(w, r)
+			return
+		}
+		next(w, r)
+	}
 }
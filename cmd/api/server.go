@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"        // New import
 	"os/signal" // New import
@@ -20,6 +21,48 @@ func (app *application) serve() error {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+	go app.handleReloads()
+
+	// jobsCtx is cancelled on shutdown to stop the periodic jobs, which run
+	// as background tasks so that shutdown waits for them.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	app.background(func() {
+		app.purgeIdempotencyKeys(jobsCtx)
+	})
+
+	var adminSrv *http.Server
+	if addr := adminAddr(app.config); addr != "" {
+		adminSrv = &http.Server{
+			Addr:        addr,
+			Handler:     app.adminRoutes(),
+			IdleTimeout: time.Minute,
+			ReadTimeout: 10 * time.Second,
+			// CPU profiles and execution traces take as long as the client
+			// asks for, 30 seconds by default.
+			WriteTimeout: 2 * time.Minute,
+		}
+
+		// Listen here rather than in the goroutine so that a taken port stops
+		// the server from starting.
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		app.logger.PrintInfo("starting admin server", map[string]string{
+			"addr": addr,
+		})
+
+		go func() {
+			err := adminSrv.Serve(ln)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]string{"addr": addr})
+			}
+		}()
+	}
+
 	shutdownError := make(chan error)
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -31,9 +74,21 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
+		// Fail readiness probes first, and give load balancers time to
+		// notice, so that no new traffic arrives once the listener closes.
+		app.health.shuttingDown.Store(true)
+		time.Sleep(app.config.shutdownDelay)
+
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
 
+		if adminSrv != nil {
+			err := adminSrv.Shutdown(ctx)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"addr": adminSrv.Addr})
+			}
+		}
+
 		err := srv.Shutdown(ctx)
 		if err != nil {
 			shutdownError <- err
@@ -43,7 +98,14 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
+		stopJobs()
 		app.wg.Wait()
+
+		err = app.tracer.Shutdown(ctx)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
 		shutdownError <- nil
 
 	}()
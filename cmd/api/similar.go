@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/validator"
+)
+
+func (app *application) similarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	limit := app.readInt(r.URL.Query(), "limit", 10, v)
+
+	v.CheckCode(limit > 0, "limit", "too_small", "must be greater than zero")
+	v.CheckCode(limit <= 20, "limit", "too_large", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	_, err = app.modelsFor(r).Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	similar, err := app.modelsFor(r).Similarities.GetForMovie(id, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"similar": similar}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshSimilarities updates the precomputed neighbours of movies that
+// were just written, in the background so the write isn't held up.
+func (app *application) refreshSimilarities(ids ...int64) {
+	app.background(func() {
+		for _, id := range ids {
+			err := app.models.Similarities.Refresh(id)
+			if err != nil {
+				app.logger.PrintError(err, map[string]string{"movie_id": strconv.FormatInt(id, 10)})
+			}
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"greenlight.bcc/internal/assert"
+)
+
+func TestSimilarMovies(t *testing.T) {
+	app := newTestApplication(t, false)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "valid ID",
+			urlPath:  "/v1/movies/1/similar",
+			wantCode: http.StatusOK,
+			wantBody: `{"similar":[{"movie":{"id":2,"title":"Similar Mock","year":2022,"runtime":"98 mins","genres":["comedy"],"version":1},"score":0.65}]}`,
+		},
+		{
+			name:     "no neighbours",
+			urlPath:  "/v1/movies/13/similar",
+			wantCode: http.StatusOK,
+			wantBody: `{"similar":[]}`,
+		},
+		{
+			name:     "non-existent movie",
+			urlPath:  "/v1/movies/100/similar",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "error fetching movie",
+			urlPath:  "/v1/movies/11/similar",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "error fetching neighbours",
+			urlPath:  "/v1/movies/12/similar",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "non-valid limit",
+			urlPath:  "/v1/movies/1/similar?limit=50",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "negative ID",
+			urlPath:  "/v1/movies/-1/similar",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
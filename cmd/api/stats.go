@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/validator"
+)
+
+// statsCacheSize bounds the number of filter combinations cached at once.
+const statsCacheSize = 100
+
+type statsCacheEntry struct {
+	stats   *data.MovieStats
+	expires time.Time
+}
+
+// statsCache holds recently computed catalogue statistics, keyed by the
+// filters they were computed for, so that dashboards polling the same view
+// share one set of queries per TTL.
+type statsCache struct {
+	mu      sync.Mutex
+	entries map[string]statsCacheEntry
+}
+
+func newStatsCache() *statsCache {
+	return &statsCache{entries: make(map[string]statsCacheEntry)}
+}
+
+func (c *statsCache) get(key string) (*data.MovieStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.stats, true
+}
+
+func (c *statsCache) set(key string, stats *data.MovieStats, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= statsCacheSize {
+		now := time.Now()
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= statsCacheSize {
+			c.entries = make(map[string]statsCacheEntry)
+		}
+	}
+
+	c.entries[key] = statsCacheEntry{stats: stats, expires: time.Now().Add(ttl)}
+}
+
+func statsCacheKey(movieFilters data.MovieFilters) (string, error) {
+	js, err := json.Marshal(movieFilters)
+	return string(js), err
+}
+
+func (app *application) movieStatsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	movieFilters := app.readMovieFilters(qs, v)
+
+	if data.ValidateMovieFilters(v, movieFilters); !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	key, err := statsCacheKey(movieFilters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	stats, ok := app.stats.get(key)
+	if !ok {
+		stats, err = app.modelsFor(r).Movies.Stats(movieFilters)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.stats.set(key, stats, app.config.stats.cacheTTL)
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"stats": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"greenlight.bcc/internal/assert"
+	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/validator"
+)
+
+func TestMovieStats(t *testing.T) {
+	app := newTestApplication(t, false)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "all movies",
+			urlPath:  "/v1/stats/movies",
+			wantCode: http.StatusOK,
+			wantBody: `"runtime":{"min":95,"max":105,"average":100,"p25":97.5,"median":100,"p75":102.5,"p90":104}`,
+		},
+		{
+			name:     "filtered",
+			urlPath:  "/v1/stats/movies?genres=comedy&year_gte=2000",
+			wantCode: http.StatusOK,
+			wantBody: `"by_year":[{"year":2023,"count":2}]`,
+		},
+		{
+			name:     "invalid filter",
+			urlPath:  "/v1/stats/movies?year_gte=2000&year_gt=1999",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "error while aggregating",
+			urlPath:  "/v1/stats/movies?title=error",
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "without permission",
+			urlPath:  "/v1/stats/movies",
+			token:    "kkkkkkkkkkkkkkkkkkkkkkkkkk",
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tt.token
+			if token == "" {
+				token = "abcdefghijklmnopqrstuvwxyz"
+			}
+
+			code, _, body := ts.getCustomHeaders(t, tt.urlPath, map[string]string{"Authorization": "Bearer " + token})
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestMovieStatsCache(t *testing.T) {
+	app := newTestApplication(t, false)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	key, err := statsCacheKey(app.readMovieFilters(url.Values{"genres": {"cached"}}, validator.New()))
+	assert.NilError(t, err)
+
+	app.stats.set(key, &data.MovieStats{Total: 42}, time.Minute)
+
+	_, _, body := ts.get(t, "/v1/stats/movies?genres=cached")
+	assert.StringContains(t, body, `"total":42`)
+
+	_, _, body = ts.get(t, "/v1/stats/movies?genres=other")
+	assert.StringContains(t, body, `"total":2`)
+
+	app.stats.set(key, &data.MovieStats{Total: 42}, -time.Second)
+
+	_, _, body = ts.get(t, "/v1/stats/movies?genres=cached")
+	assert.StringContains(t, body, `"total":2`)
+}
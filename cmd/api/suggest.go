@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/suggest"
+	"greenlight.bcc/internal/validator"
+)
+
+// suggestMoviesHandler serves search-as-you-type suggestions for titles and
+// genres from the in-memory index, without touching the database. People
+// aren't suggested because the catalogue doesn't record cast or crew.
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Query string
+		Limit int
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Query = app.readString(qs, "q", "")
+	input.Limit = app.readInt(qs, "limit", 10, v)
+
+	v.CheckCode(input.Query != "", "q", "required", "must be provided")
+	v.CheckCode(len(input.Query) <= 100, "q", "too_long", "must not be more than 100 bytes long")
+	v.CheckCode(input.Limit > 0, "limit", "too_small", "must be greater than zero")
+	v.CheckCode(input.Limit <= 20, "limit", "too_large", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v)
+		return
+	}
+
+	suggestions := app.suggestions.Suggest(input.Query, input.Limit)
+
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// loadSuggestions fills the suggestion index from the database at startup.
+// From then on the handlers that write movies keep it up to date.
+func (app *application) loadSuggestions() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var items []suggest.Item
+	err := app.models.Movies.Export(ctx, data.MovieFilters{}, func(movie *data.Movie) error {
+		items = append(items, suggest.Item{ID: movie.ID, Title: movie.Title, Genres: movie.Genres})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	app.suggestions.Load(items)
+
+	app.logger.PrintInfo("suggestion index loaded", map[string]string{
+		"movies": strconv.Itoa(app.suggestions.Len()),
+	})
+
+	return nil
+}
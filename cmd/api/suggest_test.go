@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"greenlight.bcc/internal/assert"
+	"greenlight.bcc/internal/suggest"
+)
+
+func TestSuggestMovies(t *testing.T) {
+	app := newTestApplication(t, false)
+	app.suggestions.Set(1, "Star Wars", []string{"sci-fi", "adventure"})
+	app.suggestions.Set(3, "Stargate", []string{"sci-fi"})
+	app.suggestions.Set(4, "A Star Is Born", []string{"drama", "romance"})
+	app.suggestions.Set(5, "Sunset Boulevard", []string{"drama", "noir"})
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "title prefix",
+			urlPath:  "/v1/movies/suggest?q=star",
+			wantCode: http.StatusOK,
+			wantBody: `{"suggestions":[{"text":"Stargate","kind":"title","movie_id":3},{"text":"Star Wars","kind":"title","movie_id":1},{"text":"A Star Is Born","kind":"title","movie_id":4}]}`,
+		},
+		{
+			name:     "later word",
+			urlPath:  "/v1/movies/suggest?q=WARS",
+			wantCode: http.StatusOK,
+			wantBody: `{"suggestions":[{"text":"Star Wars","kind":"title","movie_id":1}]}`,
+		},
+		{
+			name:     "genres by use",
+			urlPath:  "/v1/movies/suggest?q=s",
+			wantCode: http.StatusOK,
+			wantBody: `{"suggestions":[{"text":"sci-fi","kind":"genre"},{"text":"Stargate","kind":"title","movie_id":3}`,
+		},
+		{
+			name:     "limit",
+			urlPath:  "/v1/movies/suggest?q=s&limit=1",
+			wantCode: http.StatusOK,
+			wantBody: `{"suggestions":[{"text":"sci-fi","kind":"genre"}]}`,
+		},
+		{
+			name:     "no match",
+			urlPath:  "/v1/movies/suggest?q=zz",
+			wantCode: http.StatusOK,
+			wantBody: `{"suggestions":[]}`,
+		},
+		{
+			name:     "missing query",
+			urlPath:  "/v1/movies/suggest",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "limit too large",
+			urlPath:  "/v1/movies/suggest?q=s&limit=21",
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+func TestSuggestionsFollowWrites(t *testing.T) {
+	app := newTestApplication(t, false)
+	app.suggestions.Set(1, "Test Mock", []string{"comedy"})
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/v1/movies/suggest?q=moc")
+	assert.StringContains(t, body, `"movie_id":1`)
+
+	ts.postForm(t, "/v1/movies", []byte(`{"title":"Moonlight","year":2016,"runtime":"111 mins","genres":["drama"]}`))
+	_, _, body = ts.get(t, "/v1/movies/suggest?q=moon")
+	assert.StringContains(t, body, `{"text":"Moonlight","kind":"title","movie_id":2}`)
+
+	ts.patchForm(t, "/v1/movies/1", []byte(`{"title":"Renamed"}`))
+	_, _, body = ts.get(t, "/v1/movies/suggest?q=moc")
+	assert.Equal(t, body, "{\"suggestions\":[]}\n")
+
+	_, _, body = ts.get(t, "/v1/movies/suggest?q=ren")
+	assert.StringContains(t, body, `"movie_id":1`)
+
+	ts.deleteReq(t, "/v1/movies/1")
+	_, _, body = ts.get(t, "/v1/movies/suggest?q=ren")
+	assert.Equal(t, body, "{\"suggestions\":[]}\n")
+}
+
+func TestSuggestionIndexLoad(t *testing.T) {
+	items := []suggest.Item{
+		{ID: 5, Title: "Sunset Boulevard", Genres: []string{"drama", "noir"}},
+		{ID: 1, Title: "Star Wars", Genres: []string{"sci-fi", "adventure"}},
+		{ID: 4, Title: "A Star Is Born", Genres: []string{"drama", "romance"}},
+		{ID: 3, Title: "Stargate", Genres: []string{"sci-fi"}},
+	}
+
+	set := suggest.New()
+	for _, item := range items {
+		set.Set(item.ID, item.Title, item.Genres)
+	}
+
+	loaded := suggest.New()
+	loaded.Set(3, "Stargate SG-1", []string{"tv"})
+	loaded.Load(items)
+
+	assert.Equal(t, loaded.Len(), set.Len())
+
+	for _, prefix := range []string{"s", "star", "wa", "born", "dr", "tv", "sg"} {
+		t.Run(prefix, func(t *testing.T) {
+			want := set.Suggest(prefix, 10)
+			got := loaded.Suggest(prefix, 10)
+
+			assert.Equal(t, len(got), len(want))
+			for i := range want {
+				assert.Equal(t, got[i], want[i])
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"greenlight.bcc/internal/jsonlog"
+	"greenlight.bcc/internal/metrics"
+	"greenlight.bcc/internal/trace"
+)
+
+// unmatchedRoute labels requests that didn't reach a registered route, so
+// that scanners probing random URLs can't create unbounded series.
+const unmatchedRoute = "unmatched"
+
+// telemetry holds the metrics served on the admin listener's /metrics.
+type telemetry struct {
+	registry *metrics.Registry
+
+	requests        *metrics.CounterVec
+	requestDuration *metrics.HistogramVec
+
+	backgroundStarted *metrics.CounterVec
+	backgroundPanics  *metrics.CounterVec
+	backgroundRunning *metrics.Gauge
+
+	mailSends *metrics.CounterVec
+}
+
+func newTelemetry() *telemetry {
+	r := metrics.NewRegistry()
+
+	return &telemetry{
+		registry:          r,
+		requests:          r.NewCounterVec("http_requests_total", "HTTP requests handled, by route pattern, method and status.", "route", "method", "status"),
+		requestDuration:   r.NewHistogramVec("http_request_duration_seconds", "Time taken to handle HTTP requests, by route pattern, method and status.", metrics.DefBuckets, "route", "method", "status"),
+		backgroundStarted: r.NewCounterVec("background_tasks_started_total", "Background tasks started."),
+		backgroundPanics:  r.NewCounterVec("background_tasks_panics_total", "Background tasks that ended in a recovered panic."),
+		backgroundRunning: r.NewGauge("background_tasks_running", "Background tasks currently running."),
+		mailSends:         r.NewCounterVec("mail_sends_total", "Emails sent, by template and outcome.", "template", "outcome"),
+	}
+}
+
+// registerDB adds the connection pool statistics from db.Stats().
+func (t *telemetry) registerDB(db *sql.DB) {
+	gauge := func(name, help string, fn func(sql.DBStats) float64) {
+		t.registry.NewGaugeFunc(name, help, func() float64 { return fn(db.Stats()) })
+	}
+	counter := func(name, help string, fn func(sql.DBStats) float64) {
+		t.registry.NewCounterFunc(name, help, func() float64 { return fn(db.Stats()) })
+	}
+
+	gauge("db_max_open_connections", "Maximum number of open connections to the database.", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	gauge("db_open_connections", "Established connections, both in use and idle.", func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	gauge("db_in_use_connections", "Connections currently in use.", func(s sql.DBStats) float64 { return float64(s.InUse) })
+	gauge("db_idle_connections", "Idle connections.", func(s sql.DBStats) float64 { return float64(s.Idle) })
+	counter("db_wait_count_total", "Connections waited for because the pool was exhausted.", func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	counter("db_wait_duration_seconds_total", "Time spent waiting for a connection.", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	counter("db_max_idle_closed_total", "Connections closed because of the idle connection limit.", func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	counter("db_max_idle_time_closed_total", "Connections closed because of the idle time limit.", func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	counter("db_max_lifetime_closed_total", "Connections closed because of the lifetime limit.", func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
+
+// registerLogs adds counts of the log entries that were never written,
+// either because sampling skipped them or because the output fell behind.
+// async is nil when logging is synchronous.
+func (t *telemetry) registerLogs(logger *jsonlog.Logger, async *jsonlog.AsyncWriter) {
+	t.registry.NewCounterFunc("log_entries_sampled_total", "Log entries skipped by sampling.", func() float64 { return float64(logger.Sampled()) })
+	if async != nil {
+		t.registry.NewCounterFunc("log_entries_dropped_total", "Log entries dropped because the log output fell behind.", func() float64 { return float64(async.Dropped()) })
+	}
+}
+
+// setRoutePattern records the route that is handling r. It does nothing for
+// requests that didn't pass through the metrics middleware.
+func (app *application) setRoutePattern(r *http.Request, pattern string) {
+	if info := app.contextGetRequestInfo(r); info != nil {
+		info.route = pattern
+	}
+}
+
+// routePattern returns the route recorded for r so far.
+func (app *application) routePattern(r *http.Request) string {
+	if info := app.contextGetRequestInfo(r); info != nil {
+		return info.route
+	}
+	return ""
+}
+
+// routePatterns mirrors the routes registered on the API router so that the
+// outermost middleware can label a request with its route pattern before
+// authentication or rate limiting have had a chance to reject it.
+type routePatterns struct {
+	app    *application
+	router *httprouter.Router
+}
+
+func (app *application) newRoutePatterns() *routePatterns {
+	return &routePatterns{app: app, router: httprouter.New()}
+}
+
+func (p *routePatterns) add(method, pattern string) {
+	p.router.Handle(method, pattern, func(_ http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		p.app.setRoutePattern(r, pattern)
+	})
+}
+
+// match records the pattern of the route that r will reach, if any.
+func (p *routePatterns) match(r *http.Request) {
+	if h, _, _ := p.router.Lookup(r.Method, r.URL.Path); h != nil {
+		h(nil, r, nil)
+	}
+}
+
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+
+	err := app.telemetry.registry.Write(w)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+// sendEmail sends an email, counting the outcome and recording the send as
+// a span of the trace in ctx.
+func (app *application) sendEmail(ctx context.Context, recipient, templateFile string, data any) error {
+	_, span := app.tracer.Start(ctx, "mailer.Send", trace.KindInternal)
+	defer span.End()
+	span.SetAttribute("mail.template", templateFile)
+
+	err := app.mailer.Send(recipient, templateFile, data)
+	span.RecordError(err)
+
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	app.telemetry.mailSends.Inc(templateFile, outcome)
+
+	return err
+}
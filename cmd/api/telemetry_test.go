@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"greenlight.bcc/internal/assert"
+	"greenlight.bcc/internal/mailer"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	app := newTestApplication(t, false)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.get(t, "/v1/movies/1")
+	ts.get(t, "/v1/movies/13")
+	ts.get(t, "/v1/movies/export")
+	ts.getCustomHeaders(t, "/v1/movies/1", nil)
+	ts.get(t, "/v1/nothing-here")
+	ts.formCustomHeaders(t, "/v1/movies/1", "BREW", nil, nil)
+
+	done := make(chan struct{})
+	app.background(func() {
+		defer close(done)
+		panic("boom")
+	})
+	<-done
+	app.wg.Wait()
+
+	// Nothing listens on port 1, so the dial fails straight away.
+	app.mailer = mailer.New("127.0.0.1", 1, "", "", "Greenlight <no-reply@example.com>")
+	err := app.sendEmail(context.Background(), "alice@example.com", "user_welcome.tmpl", map[string]any{"activationToken": "x", "userID": 1})
+	assert.Equal(t, err != nil, true)
+
+	admin := newTestServer(t, app.adminRoutes())
+	defer admin.Close()
+
+	code, header, body := admin.get(t, "/metrics")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{
+			name: "route pattern",
+			want: `http_requests_total{route="/v1/movies/:id",method="GET",status="200"} 2`,
+		},
+		{
+			name: "static segment",
+			want: `http_requests_total{route="/v1/movies/export",method="GET",status="200"} 1`,
+		},
+		{
+			name: "rejected before routing",
+			want: `http_requests_total{route="/v1/movies/:id",method="GET",status="401"} 1`,
+		},
+		{
+			name: "unmatched",
+			want: `http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		},
+		{
+			name: "nonstandard method",
+			want: `method="other"`,
+		},
+		{
+			name: "histogram",
+			want: "# TYPE http_request_duration_seconds histogram\n",
+		},
+		{
+			name: "histogram buckets",
+			want: `http_request_duration_seconds_bucket{route="/v1/movies/:id",method="GET",status="200",le="+Inf"} 2`,
+		},
+		{
+			name: "histogram count",
+			want: `http_request_duration_seconds_count{route="/v1/movies/:id",method="GET",status="200"} 2`,
+		},
+		{
+			name: "background tasks started",
+			want: "background_tasks_started_total 1\n",
+		},
+		{
+			name: "background tasks panicked",
+			want: "background_tasks_panics_total 1\n",
+		},
+		{
+			name: "background tasks running",
+			want: "background_tasks_running 0\n",
+		},
+		{
+			name: "mail failures",
+			want: `mail_sends_total{template="user_welcome.tmpl",outcome="failure"} 1`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.StringContains(t, body, tt.want)
+		})
+	}
+
+	assert.Equal(t, strings.Contains(body, `method="BREW"`), false)
+}
@@ -6,9 +6,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
-	"greenlight.bcc/internal/data"
 	"greenlight.bcc/internal/jsonlog"
+	"greenlight.bcc/internal/suggest"
+	"greenlight.bcc/internal/trace"
 )
 
 func newTestApplication(t *testing.T, enableLimiter bool) *application {
@@ -20,10 +22,18 @@ func newTestApplication(t *testing.T, enableLimiter bool) *application {
 	}
 	config.cors.trustedOrigins = []string{"https://localhost:8000"}
 	config.env = "testing"
+	config.idempotency.ttl = time.Hour
+	config.stats.cacheTTL = time.Minute
 	application := application{
-		logger: jsonlog.New(io.Discard, jsonlog.LevelFatal),
-		models: data.NewMockModels(),
-		config: config,
+		logger:      jsonlog.New(io.Discard, jsonlog.LevelFatal),
+		models:      testModels(),
+		config:      config,
+		imports:     newImportRegistry(),
+		suggestions: suggest.New(),
+		stats:       newStatsCache(),
+		telemetry:   newTelemetry(),
+		tracer:      trace.New(nil, nil),
+		health:      &health{},
 	}
 	return &application
 }
@@ -133,3 +143,26 @@ func (ts *testServer) patchForm(t *testing.T, urlPath string, data []byte) (int,
 func (ts *testServer) putForm(t *testing.T, urlPath string, data []byte) (int, http.Header, string) {
 	return form(ts, t, urlPath, http.MethodPut, data)
 }
+
+func (ts *testServer) formCustomHeaders(t *testing.T, urlPath string, method string, data []byte, headers map[string]string) (int, http.Header, string) {
+	req, err := http.NewRequest(method, ts.URL+urlPath, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, string(body)
+}
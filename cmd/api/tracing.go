@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/felixge/httpsnoop"
+	"greenlight.bcc/internal/data"
+	"greenlight.bcc/internal/trace"
+)
+
+// newTraceExporter builds the exporter chosen by -trace-exporter. The
+// returned close function releases anything the exporter opened.
+func newTraceExporter(cfg config) (trace.Exporter, func() error, error) {
+	switch cfg.trace.exporter {
+	case "otlp":
+		return trace.NewOTLPExporter(cfg.trace.endpoint, "greenlight"), func() error { return nil }, nil
+	case "file":
+		f, err := os.OpenFile(cfg.trace.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		return trace.NewFileExporter(f), f.Close, nil
+	default:
+		return nil, func() error { return nil }, nil
+	}
+}
+
+// tracing starts the server span for each request, continuing the caller's
+// trace when the request carries a valid traceparent header. The span is
+// named after the route pattern once the route is known.
+func (app *application) tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := trace.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = trace.ContextWithRemote(ctx, sc)
+		}
+
+		ctx, span := app.tracer.Start(ctx, r.Method, trace.KindServer)
+		defer span.End()
+
+		if info := app.contextGetRequestInfo(r); info != nil {
+			info.traceID = span.SpanContext().TraceID.String()
+		}
+
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+
+		metrics := httpsnoop.CaptureMetrics(next, w, r.WithContext(ctx))
+
+		route := app.routePattern(r)
+		span.SetName(r.Method + " " + route)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.status_code", fmt.Sprint(metrics.Code))
+		if metrics.Code >= 500 {
+			span.RecordError(fmt.Errorf("%d %s", metrics.Code, http.StatusText(metrics.Code)))
+		}
+	})
+}
+
+// traced records the time spent in a middleware, including everything it
+// calls, as a span.
+func (app *application) traced(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := app.tracer.Start(r.Context(), "middleware "+name, trace.KindInternal)
+		defer span.End()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tracedHandler records the time spent in a route's handler as a span.
+func (app *application) tracedHandler(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := app.tracer.Start(r.Context(), "handler "+pattern, trace.KindInternal)
+		defer span.End()
+
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// startSpan starts a span for an operation that is part of handling r. The
+// caller must end it.
+func (app *application) startSpan(r *http.Request, name string) *trace.Span {
+	_, span := app.tracer.Start(r.Context(), name, trace.KindInternal)
+	return span
+}
+
+// backgroundContext returns a context that carries r's span but, unlike
+// r.Context(), isn't cancelled when the response has been sent. It is for
+// work started with app.background.
+func (app *application) backgroundContext(r *http.Request) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.FromContext(r.Context()))
+}
+
+// modelsFor returns the models with each call recorded as a span of r's
+// trace.
+func (app *application) modelsFor(r *http.Request) data.Models {
+	return app.models.Traced(r.Context(), app.tracer)
+}
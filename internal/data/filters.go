@@ -5,19 +5,32 @@ import "strings"
 import "math"
 
 type Filters struct {
-	Page         int
-	PageSize     int
-	Sort         string
-	SortSafelist []string
+	Page          int
+	PageSize      int
+	Sort          string
+	SortSafelist  []string
+	Fields        []string
+	FieldSafelist []string
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.CheckCode(f.Page > 0, "page", "too_small", "must be greater than zero")
+	v.CheckCode(f.Page <= 10_000_000, "page", "too_large", "must be a maximum of 10 million")
+	v.CheckCode(f.PageSize > 0, "page_size", "too_small", "must be greater than zero")
+	v.CheckCode(f.PageSize <= 100, "page_size", "too_large", "must be a maximum of 100")
 
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.CheckCode(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "not_permitted", "invalid sort value")
+
+	ValidateFields(v, f.Fields, f.FieldSafelist)
+}
+
+// ValidateFields checks a sparse fieldset requested with fields= against
+// the fields the endpoint allows.
+func ValidateFields(v *validator.Validator, fields []string, safelist []string) {
+	for _, field := range fields {
+		v.CheckCode(validator.PermittedValue(field, safelist...), "fields", "not_permitted", "invalid fields value")
+	}
+	v.CheckCode(validator.Unique(fields), "fields", "duplicate", "must not contain duplicate values")
 }
 
 func (f Filters) sortColumn() string {
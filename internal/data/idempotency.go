@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"greenlight.bcc/internal/validator"
+)
+
+// IdempotencyKey records the response to the first request sent with a
+// client-chosen Idempotency-Key, so that retries of that request can be
+// answered without running it again. Keys are scoped to a user; anonymous
+// requests share user ID 0, so their keys include the request fingerprint.
+// Status is 0 while the first request is still
+// being handled.
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	Fingerprint []byte
+	Status      int
+	Header      map[string][]string
+	Body        []byte
+	Expiry      time.Time
+}
+
+func ValidateIdempotencyKey(v *validator.Validator, key string) {
+	v.CheckCode(key != "", "idempotency_key", "required", "must be provided")
+	v.CheckCode(len(key) <= 255, "idempotency_key", "too_long", "must not be more than 255 bytes long")
+}
+
+type IdempotencyKeyModel struct {
+	DB *sql.DB
+}
+
+// Reserve claims key for a new request. If the key is already held by an
+// unexpired request, finished or not, nothing is claimed and that request's
+// record is returned instead. Expired keys are taken over.
+func (m IdempotencyKeyModel) Reserve(key *IdempotencyKey) (*IdempotencyKey, error) {
+	query := `
+	INSERT INTO idempotency_keys (user_id, key, fingerprint, expiry)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (user_id, key) DO UPDATE
+	SET fingerprint = EXCLUDED.fingerprint, status = 0, header = '{}', body = '',
+		created_at = NOW(), expiry = EXCLUDED.expiry
+	WHERE idempotency_keys.expiry < NOW()
+	RETURNING user_id`
+
+	args := []any{key.UserID, key.Key, key.Fingerprint, key.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID int64
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&userID)
+	if err == nil {
+		return nil, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	query = `
+	SELECT fingerprint, status, header, body, expiry
+	FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2`
+
+	existing := IdempotencyKey{UserID: key.UserID, Key: key.Key}
+	var header []byte
+
+	err = m.DB.QueryRowContext(ctx, query, key.UserID, key.Key).Scan(
+		&existing.Fingerprint,
+		&existing.Status,
+		&header,
+		&existing.Body,
+		&existing.Expiry,
+	)
+	if err != nil {
+		switch {
+		// The holder deleted the key between the two queries, which only
+		// happens when its request failed; the client should retry.
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(header, &existing.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &existing, nil
+}
+
+// Complete stores the response to the request holding key.
+func (m IdempotencyKeyModel) Complete(key *IdempotencyKey) error {
+	header, err := json.Marshal(key.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE idempotency_keys
+	SET status = $1, header = $2, body = $3
+	WHERE user_id = $4 AND key = $5`
+
+	args := []any{key.Status, header, key.Body, key.UserID, key.Key}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Delete releases key, so that the next request sent with it runs afresh.
+func (m IdempotencyKeyModel) Delete(userID int64, key string) error {
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+// DeleteExpired removes every expired key and returns how many there were.
+// Reserve takes over an expired key when it's reused, but most keys never
+// are.
+func (m IdempotencyKeyModel) DeleteExpired() (int64, error) {
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// MockIdempotencyKeyModel keeps keys in memory, since replaying a response
+// depends on what an earlier request stored. A key of "error" fails.
+type MockIdempotencyKeyModel struct {
+	mu   sync.Mutex
+	keys map[string]*IdempotencyKey
+}
+
+func NewMockIdempotencyKeyModel() *MockIdempotencyKeyModel {
+	return &MockIdempotencyKeyModel{keys: make(map[string]*IdempotencyKey)}
+}
+
+func (m *MockIdempotencyKeyModel) Reserve(key *IdempotencyKey) (*IdempotencyKey, error) {
+	if key.Key == "error" {
+		return nil, errMock
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := mockIdempotencyKeyID(key.UserID, key.Key)
+	if existing, ok := m.keys[id]; ok && existing.Expiry.After(time.Now()) {
+		copied := *existing
+		copied.Body = append([]byte(nil), existing.Body...)
+		return &copied, nil
+	}
+
+	copied := *key
+	m.keys[id] = &copied
+	return nil, nil
+}
+
+func (m *MockIdempotencyKeyModel) Complete(key *IdempotencyKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copied := *key
+	m.keys[mockIdempotencyKeyID(key.UserID, key.Key)] = &copied
+	return nil
+}
+
+func (m *MockIdempotencyKeyModel) Delete(userID int64, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.keys, mockIdempotencyKeyID(userID, key))
+	return nil
+}
+
+func (m *MockIdempotencyKeyModel) DeleteExpired() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	for id, key := range m.keys {
+		if !key.Expiry.After(time.Now()) {
+			delete(m.keys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func mockIdempotencyKeyID(userID int64, key string) string {
+	js, _ := json.Marshal([]any{userID, key})
+	return string(js)
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
@@ -9,18 +10,31 @@ import (
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict = errors.New("edit conflict")
+
+	errMock = errors.New("mock error")
 )
 
+// querier is implemented by both *sql.DB and *sql.Tx, so that a model's
+// writes can also be run as part of another model's transaction.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Models struct {
 	Movies interface {
 		Insert(movie *Movie) error
+		InsertBatch(movies []*Movie) error
 		Get(id int64) (*Movie, error)
 		Update(movie *Movie) error
-		Delete(id int64) error
-		GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
+		Delete(id int64, version int32) error
+		GetAll(movieFilters MovieFilters, filters Filters) ([]*Movie, Metadata, error)
+		Facets(movieFilters MovieFilters, names []string) (map[string][]FacetCount, error)
+		Stats(movieFilters MovieFilters) (*MovieStats, error)
+		Export(ctx context.Context, movieFilters MovieFilters, fn func(movie *Movie) error) error
 	}
 	Users interface {
 		Insert(user *User) error
+		Get(id int64) (*User, error)
 		GetByEmail(email string) (*User, error)
 		Update(user *User) error
 		GetForToken(tokenScope, tokenPlaintext string) (*User, error)
@@ -30,6 +44,24 @@ type Models struct {
 		Insert(token *Token) error
 		New(userID int64, ttl time.Duration, scope string) (*Token, error)
 	}
+	Proposals interface {
+		Insert(proposal *Proposal) error
+		Get(id int64) (*Proposal, error)
+		GetAll(status string, filters Filters) ([]*Proposal, Metadata, error)
+		GetPendingForMovies(movieIDs []int64) (map[int64][]*Proposal, error)
+		Review(proposal *Proposal) error
+	}
+	Similarities interface {
+		GetForMovie(movieID int64, limit int) ([]*SimilarMovie, error)
+		Refresh(movieID int64) error
+		EnsureBuilt() error
+	}
+	IdempotencyKeys interface {
+		Reserve(key *IdempotencyKey) (*IdempotencyKey, error)
+		Complete(key *IdempotencyKey) error
+		Delete(userID int64, key string) error
+		DeleteExpired() (int64, error)
+	}
 	Permissions interface {
 		GetAllForUser(userID int64) (Permissions, error)
 		AddForUser(userID int64, codes ...string) error
@@ -42,6 +74,9 @@ func NewModels(db *sql.DB) Models {
 		Users: UserModel{DB: db},
 		Tokens: TokenModel{DB:db},
 		Permissions: PermissionModel{DB: db},
+		Proposals: ProposalModel{DB: db},
+		IdempotencyKeys: IdempotencyKeyModel{DB: db},
+		Similarities: SimilarityModel{DB: db},
 	}
 }
 
@@ -51,5 +86,8 @@ func NewMockModels() Models {
 	Users: MockUserModel{},
 	Tokens: MockTokenModel{},
 	Permissions: MockPermissionModel{},
+	Proposals: MockProposalModel{},
+	IdempotencyKeys: NewMockIdempotencyKeyModel(),
+	Similarities: MockSimilarityModel{},
 	}
 }
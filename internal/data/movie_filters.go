@@ -0,0 +1,208 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"greenlight.bcc/internal/validator"
+)
+
+// Range bounds a value from below, above or both. Nil bounds are left open.
+type Range[T int | time.Time] struct {
+	GT  *T
+	GTE *T
+	LT  *T
+	LTE *T
+}
+
+func (r Range[T]) lower() *T {
+	if r.GTE != nil {
+		return r.GTE
+	}
+	return r.GT
+}
+
+func (r Range[T]) upper() *T {
+	if r.LTE != nil {
+		return r.LTE
+	}
+	return r.LT
+}
+
+// MovieFilters narrows the movies returned by GetAll and Export. Genres
+// must all be present on a movie, at least one of GenresAny must be, and
+// none of ExcludeGenres may be. Fuzzy widens the Title search to titles
+// that are merely similar. Zero values leave a filter unset.
+type MovieFilters struct {
+	Title         string
+	Fuzzy         bool
+	Genres        []string
+	GenresAny     []string
+	ExcludeGenres []string
+	Year          Range[int]
+	Runtime       Range[int]
+	CreatedAt     Range[time.Time]
+}
+
+func ValidateMovieFilters(v *validator.Validator, f MovieFilters) {
+	for key, genres := range map[string][]string{"genres": f.Genres, "genres_any": f.GenresAny, "exclude_genres": f.ExcludeGenres} {
+		v.CheckCode(validator.Unique(genres), key, "duplicate", "must not contain duplicate values")
+	}
+
+	validateRange(v, "year", f.Year, func(a, b int) bool { return a <= b })
+	validateRange(v, "runtime", f.Runtime, func(a, b int) bool { return a <= b })
+	validateRange(v, "created_at", f.CreatedAt, func(a, b time.Time) bool { return !a.After(b) })
+
+	for key, r := range map[string]Range[int]{"year": f.Year, "runtime": f.Runtime} {
+		for suffix, bound := range map[string]*int{"_gt": r.GT, "_gte": r.GTE, "_lt": r.LT, "_lte": r.LTE} {
+			v.CheckCode(bound == nil || *bound > 0, key+suffix, "too_small", "must be a positive integer")
+		}
+	}
+}
+
+func validateRange[T int | time.Time](v *validator.Validator, key string, r Range[T], lessOrEqual func(a, b T) bool) {
+	v.CheckCode(r.GT == nil || r.GTE == nil, key+"_gte", "conflict", "must not be used together with "+key+"_gt")
+	v.CheckCode(r.LT == nil || r.LTE == nil, key+"_lte", "conflict", "must not be used together with "+key+"_lt")
+
+	if lower, upper := r.lower(), r.upper(); lower != nil && upper != nil {
+		v.CheckCode(lessOrEqual(*lower, *upper), key, "out_of_range", "lower bound must not be greater than upper bound")
+	}
+}
+
+// queryArgs collects the arguments of a query as it's built up. User
+// values are only ever passed as arguments and referred to by the
+// placeholder add returns, so the SQL text never contains them.
+type queryArgs []any
+
+func (a *queryArgs) add(value any) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// where composes the filters into the condition of a WHERE clause.
+func (f MovieFilters) where(args *queryArgs) string {
+	var clauses []string
+
+	if f.Title != "" {
+		clauses = append(clauses, f.titleMatch(args))
+	}
+	if len(f.Genres) > 0 {
+		clauses = append(clauses, "genres @> "+args.add(pq.Array(f.Genres)))
+	}
+	if len(f.GenresAny) > 0 {
+		clauses = append(clauses, "genres && "+args.add(pq.Array(f.GenresAny)))
+	}
+	if len(f.ExcludeGenres) > 0 {
+		clauses = append(clauses, "NOT genres && "+args.add(pq.Array(f.ExcludeGenres)))
+	}
+
+	clauses = append(clauses, rangeClauses(args, "year", f.Year)...)
+	clauses = append(clauses, rangeClauses(args, "runtime", f.Runtime)...)
+	clauses = append(clauses, rangeClauses(args, "created_at", f.CreatedAt)...)
+
+	if len(clauses) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(clauses, " AND ")
+}
+
+// titleMatch matches titles containing every word of f.Title, the last
+// words possibly only typed in part. With f.Fuzzy it also falls back to
+// trigram similarity, so that misspelt titles are still found; that's
+// opt-in because it matches far more loosely than the word search does.
+// Without it, a title with no words to search for matches nothing.
+func (f MovieFilters) titleMatch(args *queryArgs) string {
+	var clauses []string
+
+	if query := prefixTSQuery(f.Title); query != "" {
+		clauses = append(clauses, fmt.Sprintf("to_tsvector('simple', title) @@ to_tsquery('simple', %s)", args.add(query)))
+	}
+	if f.Fuzzy {
+		clauses = append(clauses, "title % "+args.add(f.Title))
+	}
+
+	switch len(clauses) {
+	case 0:
+		return "FALSE"
+	case 1:
+		return clauses[0]
+	default:
+		return "(" + strings.Join(clauses, " OR ") + ")"
+	}
+}
+
+// titleRank scores how well titles match f.Title, for sort=relevance. Like
+// titleMatch, it only counts trigram similarity with f.Fuzzy.
+func (f MovieFilters) titleRank(args *queryArgs) string {
+	var terms []string
+
+	if query := prefixTSQuery(f.Title); query != "" {
+		terms = append(terms, fmt.Sprintf("ts_rank(to_tsvector('simple', title), to_tsquery('simple', %s))", args.add(query)))
+	}
+	if f.Fuzzy {
+		terms = append(terms, fmt.Sprintf("similarity(title, %s)", args.add(f.Title)))
+	}
+
+	if len(terms) == 0 {
+		return "0"
+	}
+	return strings.Join(terms, " + ")
+}
+
+// escapedTitle is the title with the characters that are special in HTML
+// escaped. Titles are plain text, so they must be escaped before being
+// marked up, or a title containing a tag would be passed on as HTML.
+const escapedTitle = `replace(replace(replace(title, '&', '&amp;'), '<', '&lt;'), '>', '&gt;')`
+
+// titleHeadline marks up the words of the title that matched f.Title,
+// returning an HTML fragment. Titles only found by trigram similarity come
+// back unmarked. The parser reads the escapes as entities rather than
+// words, so they are never marked themselves.
+func (f MovieFilters) titleHeadline(args *queryArgs) string {
+	query := prefixTSQuery(f.Title)
+	if query == "" {
+		return escapedTitle
+	}
+
+	return fmt.Sprintf("ts_headline('simple', %s, to_tsquery('simple', %s), 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')", escapedTitle, args.add(query))
+}
+
+// prefixTSQuery turns free text into a tsquery matching every word as a
+// prefix, so "star wa" finds "Star Wars". Anything but letters and digits
+// is dropped, which keeps tsquery operators in the input from being
+// interpreted.
+func prefixTSQuery(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+func rangeClauses[T int | time.Time](args *queryArgs, column string, r Range[T]) []string {
+	bounds := []struct {
+		op    string
+		value *T
+	}{
+		{">", r.GT},
+		{">=", r.GTE},
+		{"<", r.LT},
+		{"<=", r.LTE},
+	}
+
+	var clauses []string
+	for _, bound := range bounds {
+		if bound.value != nil {
+			clauses = append(clauses, column+" "+bound.op+" "+args.add(*bound.value))
+		}
+	}
+
+	return clauses
+}
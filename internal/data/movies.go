@@ -7,6 +7,7 @@ import "github.com/lib/pq"
 import "errors"
 import "context"
 import "fmt"
+import "strings"
 
 type Movie struct {
 	ID        int64     `json:"id"`
@@ -16,20 +17,25 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitempty"`
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
+	Highlight string    `json:"highlight,omitempty"`
 }
 
+// SortRelevance orders title searches by how well each movie matches. It
+// isn't a column, so GetAll handles it rather than Filters.sortColumn.
+const SortRelevance = "relevance"
+
 func ValidateMovie(v *validator.Validator, movie *Movie) {
-	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
-	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
-	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	v.CheckCode(movie.Title != "", "title", "required", "must be provided")
+	v.CheckCode(len(movie.Title) <= 500, "title", "too_long", "must not be more than 500 bytes long")
+	v.CheckCode(movie.Year != 0, "year", "required", "must be provided")
+	v.CheckCode(movie.Year >= 1888, "year", "too_small", "must be greater than 1888")
+	v.CheckCode(movie.Year <= int32(time.Now().Year()), "year", "out_of_range", "must not be in the future")
+	v.CheckCode(movie.Runtime != 0, "runtime", "required", "must be provided")
+	v.CheckCode(movie.Runtime > 0, "runtime", "too_small", "must be a positive integer")
+	v.CheckCode(movie.Genres != nil, "genres", "required", "must be provided")
+	v.CheckCode(len(movie.Genres) >= 1, "genres", "too_few", "must contain at least 1 genre")
+	v.CheckCode(len(movie.Genres) <= 5, "genres", "too_many", "must not contain more than 5 genres")
+	v.CheckCode(validator.Unique(movie.Genres), "genres", "duplicate", "must not contain duplicate values")
 }
 
 type MovieModel struct {
@@ -37,6 +43,14 @@ type MovieModel struct {
 }
 
 func (m MovieModel) Insert(movie *Movie) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.insert(ctx, m.DB, movie)
+}
+
+// insert is Insert run on q, which may be a caller's transaction.
+func (m MovieModel) insert(ctx context.Context, q querier, movie *Movie) error {
 	query := `
 INSERT INTO movies (title, year, runtime, genres)
 VALUES ($1, $2, $3, $4)
@@ -44,10 +58,42 @@ RETURNING id, created_at, version`
 
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	return q.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+}
+
+// InsertBatch inserts movies in a single transaction, so either every movie
+// in the batch is written or none are.
+func (m MovieModel) InsertBatch(movies []*Movie) error {
+	query := `
+INSERT INTO movies (title, year, runtime, genres)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, movie := range movies {
+		args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+		err = stmt.QueryRowContext(ctx, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // Add a placeholder method for fetching a specific record from the movies table.
@@ -90,6 +136,14 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 // Add a placeholder method for updating a specific record in the movies table.
 func (m MovieModel) Update(movie *Movie) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.update(ctx, m.DB, movie)
+}
+
+// update is Update run on q, which may be a caller's transaction.
+func (m MovieModel) update(ctx context.Context, q querier, movie *Movie) error {
 	query := `
 UPDATE movies
 SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
@@ -105,10 +159,7 @@ RETURNING version`
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := q.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -121,20 +172,22 @@ RETURNING version`
 	return nil
 }
 
-// Add a placeholder method for deleting a specific record from the movies table.
-func (m MovieModel) Delete(id int64) error {
+// Delete removes the movie with the given id. A non-zero version makes the
+// delete conditional on the movie still being at that version, returning
+// ErrEditConflict if it has since been changed or deleted.
+func (m MovieModel) Delete(id int64, version int32) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
 	DELETE FROM movies
-	WHERE id = $1`
+	WHERE id = $1 AND ($2 = 0 OR version = $2)`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, version)
 	if err != nil {
 		return err
 	}
@@ -145,26 +198,45 @@ func (m MovieModel) Delete(id int64) error {
 	}
 
 	if rowsAffected == 0 {
+		if version != 0 {
+			return ErrEditConflict
+		}
 		return ErrRecordNotFound
 	}
 
 	return nil
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// GetAll returns the page of movies matching movieFilters. If
+// filters.Fields is set only those columns, plus the id, are read and the
+// rest of each Movie is left at its zero value. When searching by title
+// each movie's Highlight holds its title with the matched words marked,
+// and the "relevance" sort orders the best matches first.
+func (m MovieModel) GetAll(movieFilters MovieFilters, filters Filters) ([]*Movie, Metadata, error) {
+	var movie Movie
+	var args queryArgs
+
+	columns, dest := movieColumns(filters.Fields, &movie)
+	if movieFilters.Title != "" {
+		columns += ", " + movieFilters.titleHeadline(&args)
+		dest = append(dest, &movie.Highlight)
+	}
+
+	orderBy := fmt.Sprintf("%s %s", filters.sortColumn(), filters.sortDirection())
+	if filters.Sort == SortRelevance {
+		orderBy = movieFilters.titleRank(&args) + " DESC"
+	}
+
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+	SELECT count(*) OVER(), %s
 	FROM movies
-	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	AND (genres @> $2 OR $2 = '{}')
-	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	WHERE %s
+	ORDER BY %s, id ASC
+	LIMIT %s OFFSET %s`, columns, movieFilters.where(&args), orderBy, args.add(filters.limit()), args.add(filters.offset()))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
-	
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -177,10 +249,209 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	totalRecords := 0
 
 	for rows.Next() {
+		movie = Movie{}
+
+		err := rows.Scan(append([]any{&totalRecords}, dest...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		scanned := movie
+		movies = append(movies, &scanned)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+const (
+	FacetGenres = "genres"
+	FacetDecade = "decade"
+)
+
+// FacetCount is the number of movies sharing one value of a facet, such as
+// the genre "drama" or the decade "1990s".
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets counts the movies matching movieFilters, across every page, by
+// each of the named facets. Genres are ordered most common first and
+// decades chronologically.
+func (m MovieModel) Facets(movieFilters MovieFilters, names []string) (map[string][]FacetCount, error) {
+	queries := map[string]string{
+		FacetGenres: `
+	SELECT genre, count(*)
+	FROM movies, unnest(genres) AS genre
+	WHERE %s
+	GROUP BY genre
+	ORDER BY count(*) DESC, genre ASC`,
+		FacetDecade: `
+	SELECT (year / 10 * 10)::text || 's', count(*)
+	FROM movies
+	WHERE %s
+	GROUP BY year / 10
+	ORDER BY year / 10 ASC`,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	facets := make(map[string][]FacetCount)
+
+	for _, name := range names {
+		var args queryArgs
+		query := fmt.Sprintf(queries[name], movieFilters.where(&args))
+
+		rows, err := m.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		counts := []FacetCount{}
+		for rows.Next() {
+			var count FacetCount
+
+			err := rows.Scan(&count.Value, &count.Count)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			counts = append(counts, count)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		facets[name] = counts
+	}
+
+	return facets, nil
+}
+
+// YearCount is the number of movies released in one year.
+type YearCount struct {
+	Year  int32 `json:"year"`
+	Count int   `json:"count"`
+}
+
+// RuntimeStats summarises the distribution of runtimes in minutes.
+type RuntimeStats struct {
+	Min     int32   `json:"min"`
+	Max     int32   `json:"max"`
+	Average float64 `json:"average"`
+	P25     float64 `json:"p25"`
+	Median  float64 `json:"median"`
+	P75     float64 `json:"p75"`
+	P90     float64 `json:"p90"`
+}
+
+type MovieStats struct {
+	Total   int          `json:"total"`
+	ByGenre []FacetCount `json:"by_genre"`
+	ByYear  []YearCount  `json:"by_year"`
+	Runtime RuntimeStats `json:"runtime"`
+	Newest  []*Movie     `json:"newest"`
+}
+
+// Stats aggregates the movies matching movieFilters: counts by genre and
+// year, the runtime distribution and the five most recently added movies.
+func (m MovieModel) Stats(movieFilters MovieFilters) (*MovieStats, error) {
+	facets, err := m.Facets(movieFilters, []string{FacetGenres})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &MovieStats{
+		ByGenre: facets[FacetGenres],
+		ByYear:  []YearCount{},
+		Newest:  []*Movie{},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var args queryArgs
+	query := fmt.Sprintf(`
+	SELECT count(*), COALESCE(min(runtime), 0), COALESCE(max(runtime), 0), COALESCE(avg(runtime), 0),
+		percentile_cont(ARRAY[0.25, 0.5, 0.75, 0.9]) WITHIN GROUP (ORDER BY runtime)
+	FROM movies
+	WHERE %s`, movieFilters.where(&args))
+
+	var percentiles []float64
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&stats.Total,
+		&stats.Runtime.Min,
+		&stats.Runtime.Max,
+		&stats.Runtime.Average,
+		(*pq.Float64Array)(&percentiles),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(percentiles) == 4 {
+		stats.Runtime.P25 = percentiles[0]
+		stats.Runtime.Median = percentiles[1]
+		stats.Runtime.P75 = percentiles[2]
+		stats.Runtime.P90 = percentiles[3]
+	}
+
+	args = nil
+	query = fmt.Sprintf(`
+	SELECT year, count(*)
+	FROM movies
+	WHERE %s
+	GROUP BY year
+	ORDER BY year ASC`, movieFilters.where(&args))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var count YearCount
+
+		err := rows.Scan(&count.Year, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		stats.ByYear = append(stats.ByYear, count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	args = nil
+	query = fmt.Sprintf(`
+	SELECT id, created_at, title, year, runtime, genres, version
+	FROM movies
+	WHERE %s
+	ORDER BY created_at DESC, id DESC
+	LIMIT 5`, movieFilters.where(&args))
+
+	newest, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer newest.Close()
+
+	for newest.Next() {
 		var movie Movie
 
-		err := rows.Scan(
-			&totalRecords, 
+		err := newest.Scan(
 			&movie.ID,
 			&movie.CreatedAt,
 			&movie.Title,
@@ -190,19 +461,111 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Version,
 		)
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, err
 		}
 
-		movies = append(movies, &movie)
+		stats.Newest = append(stats.Newest, &movie)
+	}
+	if err = newest.Err(); err != nil {
+		return nil, err
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+	return stats, nil
+}
+
+// movieColumns returns the select list for fields, which must already have
+// passed ValidateFilters, and the matching scan destinations in movie. An
+// empty fields selects every column.
+func movieColumns(fields []string, movie *Movie) (string, []any) {
+	all := []struct {
+		field  string
+		column string
+		dest   any
+	}{
+		{"id", "id", &movie.ID},
+		{"created_at", "created_at", &movie.CreatedAt},
+		{"title", "title", &movie.Title},
+		{"year", "year", &movie.Year},
+		{"runtime", "runtime", &movie.Runtime},
+		{"genres", "genres", pq.Array(&movie.Genres)},
+		{"version", "version", &movie.Version},
 	}
 
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	var columns []string
+	var dest []any
+	for _, c := range all {
+		if len(fields) == 0 || c.field == "id" || validator.PermittedValue(c.field, fields...) {
+			columns = append(columns, c.column)
+			dest = append(dest, c.dest)
+		}
+	}
 
-	return movies, metadata, nil
+	return strings.Join(columns, ", "), dest
+}
+
+// Export streams every movie matching movieFilters to fn, in id order.
+// Rows are read through a server-side cursor in batches, so memory use
+// doesn't grow with the size of the catalogue, and the export stops as soon
+// as ctx is cancelled or fn returns an error.
+func (m MovieModel) Export(ctx context.Context, movieFilters MovieFilters, fn func(movie *Movie) error) error {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var args queryArgs
+
+	query := fmt.Sprintf(`
+	DECLARE movies_export NO SCROLL CURSOR FOR
+	SELECT id, created_at, title, year, runtime, genres, version
+	FROM movies
+	WHERE %s
+	ORDER BY id ASC`, movieFilters.where(&args))
+
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rows, err := tx.QueryContext(ctx, `FETCH 500 FROM movies_export`)
+		if err != nil {
+			return err
+		}
+
+		fetched := 0
+		for rows.Next() {
+			var movie Movie
+
+			err := rows.Scan(
+				&movie.ID,
+				&movie.CreatedAt,
+				&movie.Title,
+				&movie.Year,
+				&movie.Runtime,
+				pq.Array(&movie.Genres),
+				&movie.Version,
+			)
+			if err == nil {
+				err = fn(&movie)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			fetched++
+		}
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		if fetched == 0 {
+			return nil
+		}
+	}
 }
 
 type MockMovieModel struct{}
@@ -211,26 +574,41 @@ func (m MockMovieModel) Insert(movie *Movie) error {
 	return nil
 }
 
+func (m MockMovieModel) InsertBatch(movies []*Movie) error {
+	for _, movie := range movies {
+		if movie.Title == "error" {
+			return errMock
+		}
+	}
+	for i, movie := range movies {
+		movie.ID = int64(i + 2)
+		movie.CreatedAt = time.Now()
+		movie.Version = 1
+	}
+	return nil
+}
+
 func (m MockMovieModel) Get(id int64) (*Movie, error) {
 	switch id {
 	case 1:
 		return &Movie{
-			ID: 1,
+			ID:        1,
 			CreatedAt: time.Now(),
-			Year: 2023,
-			Runtime: 105,
-			Title: "Test Mock",
-			Genres: []string{""},
+			Year:      2023,
+			Runtime:   105,
+			Title:     "Test Mock",
+			Genres:    []string{""},
 		}, nil
 	default:
 		return nil, ErrRecordNotFound
 	}
 }
+
 func (m MockMovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MockMovieModel) Delete(id int64) error {
+func (m MockMovieModel) Delete(id int64, version int32) error {
 	switch id {
 	case 1:
 		return nil
@@ -239,6 +617,64 @@ func (m MockMovieModel) Delete(id int64) error {
 	}
 }
 
-func (m MockMovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) { 
+func (m MockMovieModel) GetAll(movieFilters MovieFilters, filters Filters) ([]*Movie, Metadata, error) {
 	return nil, Metadata{}, nil
 }
+
+func (m MockMovieModel) Facets(movieFilters MovieFilters, names []string) (map[string][]FacetCount, error) {
+	if movieFilters.Title == "facets" {
+		return nil, errMock
+	}
+
+	facets := make(map[string][]FacetCount)
+	for _, name := range names {
+		switch name {
+		case FacetGenres:
+			facets[name] = []FacetCount{{Value: "comedy", Count: 2}, {Value: "drama", Count: 2}}
+		case FacetDecade:
+			facets[name] = []FacetCount{{Value: "2020s", Count: 2}}
+		}
+	}
+	return facets, nil
+}
+
+func (m MockMovieModel) Stats(movieFilters MovieFilters) (*MovieStats, error) {
+	if movieFilters.Title == "error" {
+		return nil, errMock
+	}
+
+	movie, _ := m.Get(1)
+
+	return &MovieStats{
+		Total:   2,
+		ByGenre: []FacetCount{{Value: "comedy", Count: 2}, {Value: "drama", Count: 1}},
+		ByYear:  []YearCount{{Year: 2023, Count: 2}},
+		Runtime: RuntimeStats{Min: 95, Max: 105, Average: 100, P25: 97.5, Median: 100, P75: 102.5, P90: 104},
+		Newest:  []*Movie{movie},
+	}, nil
+}
+
+func (m MockMovieModel) Export(ctx context.Context, movieFilters MovieFilters, fn func(movie *Movie) error) error {
+	if movieFilters.Title == "error" {
+		return errMock
+	}
+
+	for id := int64(1); id <= 2; id++ {
+		movie := &Movie{
+			ID:        id,
+			CreatedAt: time.Now(),
+			Year:      2023,
+			Runtime:   105,
+			Title:     "Test Mock",
+			Genres:    []string{"comedy", "drama"},
+			Version:   1,
+		}
+
+		err := fn(movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,431 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"greenlight.bcc/internal/validator"
+)
+
+// ErrMovieDeleted is returned by Review when an approved edit's movie has
+// been deleted since the proposal was made.
+var ErrMovieDeleted = errors.New("movie deleted")
+
+const (
+	ProposalPending  = "pending"
+	ProposalApproved = "approved"
+	ProposalRejected = "rejected"
+)
+
+// Proposal is a create (MovieID == 0) or edit of a movie that waits in the
+// review queue until someone with movies:approve accepts or rejects it.
+// BaseVersion is the movie version the edit was made against.
+type Proposal struct {
+	ID          int64      `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UserID      int64      `json:"user_id"`
+	MovieID     int64      `json:"movie_id,omitempty"`
+	BaseVersion int32      `json:"base_version,omitempty"`
+	Movie       Movie      `json:"movie"`
+	Status      string     `json:"status"`
+	ReviewerID  int64      `json:"reviewer_id,omitempty"`
+	Comment     string     `json:"comment,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	Version     int32      `json:"-"`
+}
+
+func ValidateProposalReview(v *validator.Validator, comment string) {
+	v.CheckCode(len(comment) <= 1000, "comment", "too_long", "must not be more than 1000 bytes long")
+}
+
+type ProposalModel struct {
+	DB *sql.DB
+}
+
+func (m ProposalModel) Insert(proposal *Proposal) error {
+	query := `
+	INSERT INTO movie_proposals (user_id, movie_id, base_version, title, year, runtime, genres)
+	VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7)
+	RETURNING id, created_at, status, version`
+
+	args := []any{
+		proposal.UserID,
+		proposal.MovieID,
+		proposal.BaseVersion,
+		proposal.Movie.Title,
+		proposal.Movie.Year,
+		proposal.Movie.Runtime,
+		pq.Array(proposal.Movie.Genres),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&proposal.ID, &proposal.CreatedAt, &proposal.Status, &proposal.Version)
+}
+
+func (m ProposalModel) Get(id int64) (*Proposal, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, created_at, user_id, COALESCE(movie_id, 0), base_version, title, year, runtime, genres,
+		status, COALESCE(reviewer_id, 0), review_comment, reviewed_at, version
+	FROM movie_proposals
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var proposal Proposal
+	var reviewedAt sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&proposal.ID,
+		&proposal.CreatedAt,
+		&proposal.UserID,
+		&proposal.MovieID,
+		&proposal.BaseVersion,
+		&proposal.Movie.Title,
+		&proposal.Movie.Year,
+		&proposal.Movie.Runtime,
+		pq.Array(&proposal.Movie.Genres),
+		&proposal.Status,
+		&proposal.ReviewerID,
+		&proposal.Comment,
+		&reviewedAt,
+		&proposal.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if reviewedAt.Valid {
+		proposal.ReviewedAt = &reviewedAt.Time
+	}
+	proposal.Movie.ID = proposal.MovieID
+
+	return &proposal, nil
+}
+
+func (m ProposalModel) GetAll(status string, filters Filters) ([]*Proposal, Metadata, error) {
+	query := `
+	SELECT count(*) OVER(), id, created_at, user_id, COALESCE(movie_id, 0), base_version, title, year, runtime, genres,
+		status, COALESCE(reviewer_id, 0), review_comment, reviewed_at, version
+	FROM movie_proposals
+	WHERE (status = $1 OR $1 = '')
+	ORDER BY created_at ASC, id ASC
+	LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	proposals := []*Proposal{}
+
+	for rows.Next() {
+		var proposal Proposal
+		var reviewedAt sql.NullTime
+
+		err := rows.Scan(
+			&totalRecords,
+			&proposal.ID,
+			&proposal.CreatedAt,
+			&proposal.UserID,
+			&proposal.MovieID,
+			&proposal.BaseVersion,
+			&proposal.Movie.Title,
+			&proposal.Movie.Year,
+			&proposal.Movie.Runtime,
+			pq.Array(&proposal.Movie.Genres),
+			&proposal.Status,
+			&proposal.ReviewerID,
+			&proposal.Comment,
+			&reviewedAt,
+			&proposal.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if reviewedAt.Valid {
+			proposal.ReviewedAt = &reviewedAt.Time
+		}
+		proposal.Movie.ID = proposal.MovieID
+
+		proposals = append(proposals, &proposal)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return proposals, metadata, nil
+}
+
+// GetPendingForMovies returns the pending edit proposals for each of
+// movieIDs, oldest first, keyed by movie ID.
+func (m ProposalModel) GetPendingForMovies(movieIDs []int64) (map[int64][]*Proposal, error) {
+	query := `
+	SELECT id, created_at, user_id, movie_id, base_version, title, year, runtime, genres,
+		status, COALESCE(reviewer_id, 0), review_comment, reviewed_at, version
+	FROM movie_proposals
+	WHERE movie_id = ANY($1) AND status = $2
+	ORDER BY created_at ASC, id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs), ProposalPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	proposals := make(map[int64][]*Proposal)
+
+	for rows.Next() {
+		var proposal Proposal
+		var reviewedAt sql.NullTime
+
+		err := rows.Scan(
+			&proposal.ID,
+			&proposal.CreatedAt,
+			&proposal.UserID,
+			&proposal.MovieID,
+			&proposal.BaseVersion,
+			&proposal.Movie.Title,
+			&proposal.Movie.Year,
+			&proposal.Movie.Runtime,
+			pq.Array(&proposal.Movie.Genres),
+			&proposal.Status,
+			&proposal.ReviewerID,
+			&proposal.Comment,
+			&reviewedAt,
+			&proposal.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if reviewedAt.Valid {
+			proposal.ReviewedAt = &reviewedAt.Time
+		}
+		proposal.Movie.ID = proposal.MovieID
+
+		proposals[proposal.MovieID] = append(proposals[proposal.MovieID], &proposal)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return proposals, nil
+}
+
+// Review records the review outcome and, for an approval, writes the movie,
+// in one transaction. The proposal is claimed first, guarded by its status
+// and version, so two reviewers can't both act on it and a create can't
+// insert the movie twice. An edit is applied only if the movie is still at
+// BaseVersion, otherwise ErrEditConflict is returned and nothing changes.
+func (m ProposalModel) Review(proposal *Proposal) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE movie_proposals
+	SET status = $1, reviewer_id = NULLIF($2, 0), review_comment = $3, reviewed_at = $4,
+		version = version + 1
+	WHERE id = $5 AND status = 'pending' AND version = $6
+	RETURNING version`
+
+	args := []any{
+		proposal.Status,
+		proposal.ReviewerID,
+		proposal.Comment,
+		proposal.ReviewedAt,
+		proposal.ID,
+		proposal.Version,
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&proposal.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if proposal.Status == ProposalApproved {
+		err = applyProposal(ctx, tx, proposal)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// applyProposal writes an approved proposal's movie within tx and records
+// the movie's id on the proposal.
+func applyProposal(ctx context.Context, tx *sql.Tx, proposal *Proposal) error {
+	movies := MovieModel{}
+	movie := &proposal.Movie
+
+	if proposal.MovieID == 0 {
+		err := movies.insert(ctx, tx, movie)
+		if err != nil {
+			return err
+		}
+
+		proposal.MovieID = movie.ID
+
+		_, err = tx.ExecContext(ctx, `UPDATE movie_proposals SET movie_id = $1 WHERE id = $2`, movie.ID, proposal.ID)
+		return err
+	}
+
+	movie.ID = proposal.MovieID
+	movie.Version = proposal.BaseVersion
+
+	err := movies.update(ctx, tx, movie)
+	if !errors.Is(err, ErrEditConflict) {
+		return err
+	}
+
+	// Tell a deleted movie apart from one that has been edited since.
+	var exists bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movies WHERE id = $1)`, proposal.MovieID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrMovieDeleted
+	}
+	return ErrEditConflict
+}
+
+type MockProposalModel struct{}
+
+func (m MockProposalModel) Insert(proposal *Proposal) error {
+	if proposal.Movie.Title == "error" {
+		return errMock
+	}
+	proposal.ID = 1
+	proposal.CreatedAt = time.Now()
+	proposal.Status = ProposalPending
+	proposal.Version = 1
+	return nil
+}
+
+func (m MockProposalModel) Get(id int64) (*Proposal, error) {
+	proposal := &Proposal{
+		ID:        id,
+		CreatedAt: time.Now(),
+		UserID:    2,
+		Movie: Movie{
+			Title:   "Test Proposal",
+			Year:    2021,
+			Runtime: 105,
+			Genres:  []string{"comedy"},
+		},
+		Status:  ProposalPending,
+		Version: 1,
+	}
+
+	switch id {
+	case 1:
+	case 2:
+		proposal.MovieID = 1
+		proposal.BaseVersion = 1
+	case 3:
+		proposal.Status = ProposalApproved
+	case 4:
+		proposal.MovieID = 1
+		proposal.BaseVersion = 7
+	case 5:
+		proposal.Version = 2
+	case 6:
+		proposal.UserID = 1
+	case 7:
+		proposal.MovieID = 2
+		proposal.BaseVersion = 1
+	case 11:
+		return nil, errMock
+	default:
+		return nil, ErrRecordNotFound
+	}
+	proposal.Movie.ID = proposal.MovieID
+
+	return proposal, nil
+}
+
+func (m MockProposalModel) GetAll(status string, filters Filters) ([]*Proposal, Metadata, error) {
+	if status == "error" {
+		return nil, Metadata{}, errMock
+	}
+	return []*Proposal{}, Metadata{}, nil
+}
+
+func (m MockProposalModel) GetPendingForMovies(movieIDs []int64) (map[int64][]*Proposal, error) {
+	proposals := make(map[int64][]*Proposal)
+	for _, id := range movieIDs {
+		switch id {
+		case 1:
+			proposal, _ := m.Get(2)
+			proposals[id] = append(proposals[id], proposal)
+		case 11:
+			return nil, errMock
+		}
+	}
+	return proposals, nil
+}
+
+func (m MockProposalModel) Review(proposal *Proposal) error {
+	if proposal.Version == 2 {
+		return ErrEditConflict
+	}
+
+	if proposal.Status == ProposalApproved {
+		if proposal.MovieID == 0 {
+			proposal.MovieID = 1
+			proposal.Movie.ID = 1
+		} else {
+			// Movie 1 is at version 1; any other movie has been deleted.
+			switch {
+			case proposal.MovieID != 1:
+				return ErrMovieDeleted
+			case proposal.BaseVersion != 1:
+				return ErrEditConflict
+			}
+			proposal.Movie.ID = proposal.MovieID
+			proposal.Movie.CreatedAt = time.Now()
+			proposal.Movie.Version = 2
+		}
+	}
+
+	proposal.Version++
+	return nil
+}
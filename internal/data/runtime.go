@@ -23,17 +23,28 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 	if err != nil {
 	return ErrInvalidRuntimeFormat
 	}
-	
-	parts := strings.Split(unquotedJSONValue, " ")
+
+	runtime, err := ParseRuntime(unquotedJSONValue)
+	if err != nil {
+	return err
+	}
+
+	*r = runtime
+	return nil
+}
+
+// ParseRuntime parses the "<n> mins" form used in JSON bodies, for inputs
+// such as CSV cells that don't arrive as JSON strings.
+func ParseRuntime(s string) (Runtime, error) {
+	parts := strings.Split(s, " ")
 	if len(parts) != 2 || parts[1] != "mins" {
-	return ErrInvalidRuntimeFormat
+	return 0, ErrInvalidRuntimeFormat
 	}
 
 	i, err := strconv.ParseInt(parts[0], 10, 32)
 	if err != nil {
-	return ErrInvalidRuntimeFormat
+	return 0, ErrInvalidRuntimeFormat
 	}
-	
-	*r = Runtime(i)
-	return nil
+
+	return Runtime(i), nil
 }
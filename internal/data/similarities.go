@@ -0,0 +1,263 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// similarNeighbours is the number of neighbours stored for each movie.
+const similarNeighbours = 20
+
+// similarityLockID is the key of the advisory lock that serializes writes to
+// movie_similarities. Two refreshes running at once read each other's
+// half-written lists and deadlock or leave them short.
+const similarityLockID int64 = 5316042917
+
+// similarityScore rates candidate movie m against movie a. The catalogue
+// records neither credits nor ratings, so the score is genre overlap (the
+// Jaccard index) weighted at 0.7 plus year proximity weighted at 0.3.
+// Only movies sharing a genre are considered neighbours.
+const similarityScore = `
+	0.7 * cardinality(ARRAY(SELECT unnest(a.genres) INTERSECT SELECT unnest(m.genres)))::real
+		/ cardinality(ARRAY(SELECT unnest(a.genres) UNION SELECT unnest(m.genres)))
+	+ 0.3 / (1 + abs(a.year - m.year) / 10.0)`
+
+type SimilarMovie struct {
+	Movie *Movie  `json:"movie"`
+	Score float64 `json:"score"`
+}
+
+// SimilarityModel maintains the precomputed neighbours of each movie in
+// movie_similarities. similar_id deliberately has no foreign key, so that
+// after a movie is deleted Refresh can still find the movies that listed it
+// and fill the gap it left.
+type SimilarityModel struct {
+	DB *sql.DB
+}
+
+func (m SimilarityModel) GetForMovie(movieID int64, limit int) ([]*SimilarMovie, error) {
+	query := `
+	SELECT m.id, m.created_at, m.title, m.year, m.runtime, m.genres, m.version, s.score
+	FROM movie_similarities s
+	INNER JOIN movies m ON m.id = s.similar_id
+	WHERE s.movie_id = $1
+	ORDER BY s.score DESC, m.id ASC
+	LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	similar := []*SimilarMovie{}
+
+	for rows.Next() {
+		var movie Movie
+		var score float64
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&score,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		similar = append(similar, &SimilarMovie{Movie: &movie, Score: score})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return similar, nil
+}
+
+// Refresh brings the neighbours up to date after the movie with the given
+// id was inserted, updated or deleted. It recomputes that movie's own
+// neighbours, adds it to other movies' lists and trims each of those back
+// to its top similarNeighbours, and recomputes the lists of movies that
+// used to include it, since it may have dropped out of them. Refreshes run
+// one at a time, waiting for any other to commit first.
+func (m SimilarityModel) Refresh(movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, similarityLockID)
+	if err != nil {
+		return err
+	}
+
+	var affected []int64
+	err = tx.QueryRowContext(ctx, `
+	SELECT COALESCE(array_agg(movie_id), '{}')
+	FROM movie_similarities
+	WHERE similar_id = $1 AND movie_id <> $1`, movieID).Scan(pq.Array(&affected))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM movie_similarities
+	WHERE movie_id = $1 OR similar_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	err = refreshNeighbours(ctx, tx, movieID)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+	INSERT INTO movie_similarities (movie_id, similar_id, score)
+	SELECT m.id, a.id, %s
+	FROM movies a
+	INNER JOIN movies m ON m.id <> a.id AND m.genres && a.genres
+	WHERE a.id = $1`, similarityScore)
+
+	_, err = tx.ExecContext(ctx, query, movieID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	DELETE FROM movie_similarities s
+	USING (
+		SELECT movie_id, similar_id,
+			row_number() OVER (PARTITION BY movie_id ORDER BY score DESC, similar_id ASC) AS rank
+		FROM movie_similarities
+		WHERE movie_id IN (SELECT movie_id FROM movie_similarities WHERE similar_id = $1)
+	) r
+	WHERE s.movie_id = r.movie_id AND s.similar_id = r.similar_id AND r.rank > $2`, movieID, similarNeighbours)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range affected {
+		err = refreshNeighbours(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// refreshNeighbours replaces the stored neighbours of one movie.
+func refreshNeighbours(ctx context.Context, tx *sql.Tx, movieID int64) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM movie_similarities WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+	INSERT INTO movie_similarities (movie_id, similar_id, score)
+	SELECT a.id, m.id, %s AS score
+	FROM movies a
+	INNER JOIN movies m ON m.id <> a.id AND m.genres && a.genres
+	WHERE a.id = $1
+	ORDER BY score DESC, m.id ASC
+	LIMIT $2`, similarityScore)
+
+	_, err = tx.ExecContext(ctx, query, movieID, similarNeighbours)
+	return err
+}
+
+// EnsureBuilt computes the neighbours of every movie if none have been
+// stored yet, such as just after the table was created. It compares every
+// pair of movies, so it's meant to run once in the background. It holds
+// the same lock as Refresh, so that two instances starting together don't
+// both build the table, and refreshes wait for the build to finish.
+func (m SimilarityModel) EnsureBuilt() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	var built bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movie_similarities)`).Scan(&built)
+	if err != nil || built {
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, similarityLockID)
+	if err != nil {
+		return err
+	}
+
+	// Another instance may have built the table while this one waited for
+	// the lock.
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movie_similarities)`).Scan(&built)
+	if err != nil || built {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+	INSERT INTO movie_similarities (movie_id, similar_id, score)
+	SELECT a.id, n.id, n.score
+	FROM movies a
+	CROSS JOIN LATERAL (
+		SELECT m.id, %s AS score
+		FROM movies m
+		WHERE m.id <> a.id AND m.genres && a.genres
+		ORDER BY score DESC, m.id ASC
+		LIMIT $1
+	) n
+	ON CONFLICT DO NOTHING`, similarityScore)
+
+	_, err = tx.ExecContext(ctx, query, similarNeighbours)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type MockSimilarityModel struct{}
+
+func (m MockSimilarityModel) GetForMovie(movieID int64, limit int) ([]*SimilarMovie, error) {
+	switch movieID {
+	case 1:
+		return []*SimilarMovie{{
+			Movie: &Movie{ID: 2, Title: "Similar Mock", Year: 2022, Runtime: 98, Genres: []string{"comedy"}, Version: 1},
+			Score: 0.65,
+		}}, nil
+	case 12:
+		return nil, errMock
+	default:
+		return []*SimilarMovie{}, nil
+	}
+}
+
+func (m MockSimilarityModel) Refresh(movieID int64) error {
+	return nil
+}
+
+func (m MockSimilarityModel) EnsureBuilt() error {
+	return nil
+}
@@ -46,8 +46,8 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 
 
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
-	v.Check(tokenPlaintext != "", "token", "must be provided")
-	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
+	v.CheckCode(tokenPlaintext != "", "token", "required", "must be provided")
+	v.CheckCode(len(tokenPlaintext) == 26, "token", "invalid_length", "must be 26 bytes long")
 }
 
 type TokenModel struct {
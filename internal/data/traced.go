@@ -0,0 +1,217 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"greenlight.bcc/internal/trace"
+)
+
+// Traced returns a copy of m in which every model method call is recorded as
+// a span, a child of the span in ctx. The models don't take a context
+// themselves, so the returned value should only be used for the request
+// that ctx belongs to.
+func (m Models) Traced(ctx context.Context, tracer *trace.Tracer) Models {
+	t := tracedModels{ctx: ctx, tracer: tracer, next: m}
+
+	return Models{
+		Movies:          tracedMovies{t},
+		Users:           tracedUsers{t},
+		Tokens:          tracedTokens{t},
+		Permissions:     tracedPermissions{t},
+		Proposals:       tracedProposals{t},
+		IdempotencyKeys: tracedIdempotencyKeys{t},
+		Similarities:    tracedSimilarities{t},
+	}
+}
+
+type tracedModels struct {
+	ctx    context.Context
+	tracer *trace.Tracer
+	next   Models
+}
+
+// span runs fn inside a span called name. Not finding a record is an
+// expected outcome rather than a failure, so it isn't recorded as an error.
+func span[T any](t tracedModels, name string, fn func() (T, error)) (T, error) {
+	_, span := t.tracer.Start(t.ctx, name, trace.KindInternal)
+	defer span.End()
+
+	result, err := fn()
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		span.RecordError(err)
+	}
+	return result, err
+}
+
+func spanErr(t tracedModels, name string, fn func() error) error {
+	_, err := span(t, name, func() (struct{}, error) {
+		return struct{}{}, fn()
+	})
+	return err
+}
+
+type tracedMovies struct{ tracedModels }
+
+func (t tracedMovies) Insert(movie *Movie) error {
+	return spanErr(t.tracedModels, "MovieModel.Insert", func() error { return t.next.Movies.Insert(movie) })
+}
+
+func (t tracedMovies) InsertBatch(movies []*Movie) error {
+	return spanErr(t.tracedModels, "MovieModel.InsertBatch", func() error { return t.next.Movies.InsertBatch(movies) })
+}
+
+func (t tracedMovies) Get(id int64) (*Movie, error) {
+	return span(t.tracedModels, "MovieModel.Get", func() (*Movie, error) { return t.next.Movies.Get(id) })
+}
+
+func (t tracedMovies) Update(movie *Movie) error {
+	return spanErr(t.tracedModels, "MovieModel.Update", func() error { return t.next.Movies.Update(movie) })
+}
+
+func (t tracedMovies) Delete(id int64, version int32) error {
+	return spanErr(t.tracedModels, "MovieModel.Delete", func() error { return t.next.Movies.Delete(id, version) })
+}
+
+func (t tracedMovies) GetAll(movieFilters MovieFilters, filters Filters) ([]*Movie, Metadata, error) {
+	var metadata Metadata
+	movies, err := span(t.tracedModels, "MovieModel.GetAll", func() ([]*Movie, error) {
+		movies, md, err := t.next.Movies.GetAll(movieFilters, filters)
+		metadata = md
+		return movies, err
+	})
+	return movies, metadata, err
+}
+
+func (t tracedMovies) Facets(movieFilters MovieFilters, names []string) (map[string][]FacetCount, error) {
+	return span(t.tracedModels, "MovieModel.Facets", func() (map[string][]FacetCount, error) {
+		return t.next.Movies.Facets(movieFilters, names)
+	})
+}
+
+func (t tracedMovies) Stats(movieFilters MovieFilters) (*MovieStats, error) {
+	return span(t.tracedModels, "MovieModel.Stats", func() (*MovieStats, error) { return t.next.Movies.Stats(movieFilters) })
+}
+
+func (t tracedMovies) Export(ctx context.Context, movieFilters MovieFilters, fn func(movie *Movie) error) error {
+	return spanErr(t.tracedModels, "MovieModel.Export", func() error { return t.next.Movies.Export(ctx, movieFilters, fn) })
+}
+
+type tracedUsers struct{ tracedModels }
+
+func (t tracedUsers) Insert(user *User) error {
+	return spanErr(t.tracedModels, "UserModel.Insert", func() error { return t.next.Users.Insert(user) })
+}
+
+func (t tracedUsers) Get(id int64) (*User, error) {
+	return span(t.tracedModels, "UserModel.Get", func() (*User, error) { return t.next.Users.Get(id) })
+}
+
+func (t tracedUsers) GetByEmail(email string) (*User, error) {
+	return span(t.tracedModels, "UserModel.GetByEmail", func() (*User, error) { return t.next.Users.GetByEmail(email) })
+}
+
+func (t tracedUsers) Update(user *User) error {
+	return spanErr(t.tracedModels, "UserModel.Update", func() error { return t.next.Users.Update(user) })
+}
+
+func (t tracedUsers) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	return span(t.tracedModels, "UserModel.GetForToken", func() (*User, error) {
+		return t.next.Users.GetForToken(tokenScope, tokenPlaintext)
+	})
+}
+
+type tracedTokens struct{ tracedModels }
+
+func (t tracedTokens) DeleteAllForUser(scope string, userID int64) error {
+	return spanErr(t.tracedModels, "TokenModel.DeleteAllForUser", func() error { return t.next.Tokens.DeleteAllForUser(scope, userID) })
+}
+
+func (t tracedTokens) Insert(token *Token) error {
+	return spanErr(t.tracedModels, "TokenModel.Insert", func() error { return t.next.Tokens.Insert(token) })
+}
+
+func (t tracedTokens) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	return span(t.tracedModels, "TokenModel.New", func() (*Token, error) { return t.next.Tokens.New(userID, ttl, scope) })
+}
+
+type tracedPermissions struct{ tracedModels }
+
+func (t tracedPermissions) GetAllForUser(userID int64) (Permissions, error) {
+	return span(t.tracedModels, "PermissionModel.GetAllForUser", func() (Permissions, error) {
+		return t.next.Permissions.GetAllForUser(userID)
+	})
+}
+
+func (t tracedPermissions) AddForUser(userID int64, codes ...string) error {
+	return spanErr(t.tracedModels, "PermissionModel.AddForUser", func() error { return t.next.Permissions.AddForUser(userID, codes...) })
+}
+
+type tracedProposals struct{ tracedModels }
+
+func (t tracedProposals) Insert(proposal *Proposal) error {
+	return spanErr(t.tracedModels, "ProposalModel.Insert", func() error { return t.next.Proposals.Insert(proposal) })
+}
+
+func (t tracedProposals) Get(id int64) (*Proposal, error) {
+	return span(t.tracedModels, "ProposalModel.Get", func() (*Proposal, error) { return t.next.Proposals.Get(id) })
+}
+
+func (t tracedProposals) GetAll(status string, filters Filters) ([]*Proposal, Metadata, error) {
+	var metadata Metadata
+	proposals, err := span(t.tracedModels, "ProposalModel.GetAll", func() ([]*Proposal, error) {
+		proposals, md, err := t.next.Proposals.GetAll(status, filters)
+		metadata = md
+		return proposals, err
+	})
+	return proposals, metadata, err
+}
+
+func (t tracedProposals) GetPendingForMovies(movieIDs []int64) (map[int64][]*Proposal, error) {
+	return span(t.tracedModels, "ProposalModel.GetPendingForMovies", func() (map[int64][]*Proposal, error) {
+		return t.next.Proposals.GetPendingForMovies(movieIDs)
+	})
+}
+
+func (t tracedProposals) Review(proposal *Proposal) error {
+	return spanErr(t.tracedModels, "ProposalModel.Review", func() error { return t.next.Proposals.Review(proposal) })
+}
+
+type tracedIdempotencyKeys struct{ tracedModels }
+
+func (t tracedIdempotencyKeys) Reserve(key *IdempotencyKey) (*IdempotencyKey, error) {
+	return span(t.tracedModels, "IdempotencyKeyModel.Reserve", func() (*IdempotencyKey, error) {
+		return t.next.IdempotencyKeys.Reserve(key)
+	})
+}
+
+func (t tracedIdempotencyKeys) Complete(key *IdempotencyKey) error {
+	return spanErr(t.tracedModels, "IdempotencyKeyModel.Complete", func() error { return t.next.IdempotencyKeys.Complete(key) })
+}
+
+func (t tracedIdempotencyKeys) Delete(userID int64, key string) error {
+	return spanErr(t.tracedModels, "IdempotencyKeyModel.Delete", func() error { return t.next.IdempotencyKeys.Delete(userID, key) })
+}
+
+func (t tracedIdempotencyKeys) DeleteExpired() (int64, error) {
+	return span(t.tracedModels, "IdempotencyKeyModel.DeleteExpired", func() (int64, error) {
+		return t.next.IdempotencyKeys.DeleteExpired()
+	})
+}
+
+type tracedSimilarities struct{ tracedModels }
+
+func (t tracedSimilarities) GetForMovie(movieID int64, limit int) ([]*SimilarMovie, error) {
+	return span(t.tracedModels, "SimilarityModel.GetForMovie", func() ([]*SimilarMovie, error) {
+		return t.next.Similarities.GetForMovie(movieID, limit)
+	})
+}
+
+func (t tracedSimilarities) Refresh(movieID int64) error {
+	return spanErr(t.tracedModels, "SimilarityModel.Refresh", func() error { return t.next.Similarities.Refresh(movieID) })
+}
+
+func (t tracedSimilarities) EnsureBuilt() error {
+	return spanErr(t.tracedModels, "SimilarityModel.EnsureBuilt", func() error { return t.next.Similarities.EnsureBuilt() })
+}
@@ -32,19 +32,19 @@ func (u *User) IsAnonymous() bool {
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
-	v.Check(email != "", "email", "must be provided")
-	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+	v.CheckCode(email != "", "email", "required", "must be provided")
+	v.CheckCode(validator.Matches(email, validator.EmailRX), "email", "invalid_format", "must be a valid email address")
 }
 
 func ValidatePasswordPlaintext(v *validator.Validator, password string) {
-	v.Check(password != "", "password", "must be provided")
-	v.Check(len(password) >= 8, "password", "must be at least 8 bytes long")
-	v.Check(len(password) <= 72, "password", "must not be more than 72 bytes long")
+	v.CheckCode(password != "", "password", "required", "must be provided")
+	v.CheckCode(len(password) >= 8, "password", "too_short", "must be at least 8 bytes long")
+	v.CheckCode(len(password) <= 72, "password", "too_long", "must not be more than 72 bytes long")
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
+	v.CheckCode(user.Name != "", "name", "required", "must be provided")
+	v.CheckCode(len(user.Name) <= 500, "name", "too_long", "must not be more than 500 bytes long")
 
 	ValidateEmail(v, user.Email)
 
@@ -112,6 +112,34 @@ func (m UserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	query := `
+	SELECT id, created_at, name, email, password_hash, activated, version
+	FROM users
+	WHERE id = $1`
+	var user User
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
 	SELECT id, created_at, name, email, password_hash, activated, version
@@ -216,6 +244,10 @@ func (m MockUserModel) Insert(user *User) error {
 	return nil
 }
 
+func (m MockUserModel) Get(id int64) (*User, error) {
+	return nil, ErrRecordNotFound
+}
+
 func (m MockUserModel) GetByEmail(email string) (*User, error) {
 	return nil, nil
 }
@@ -0,0 +1,109 @@
+package jsonlog
+
+import (
+	"time"
+)
+
+// Attr is a typed key-value pair attached to a log entry.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Int(key string, value int) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Float64(key string, value float64) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+// Duration is written as a string such as "1.5s".
+func Duration(key string, value time.Duration) Attr {
+	return Attr{Key: key, Value: value.String()}
+}
+
+// Time is written in RFC 3339 format with nanoseconds, in UTC.
+func Time(key string, value time.Time) Attr {
+	return Attr{Key: key, Value: value.UTC().Format(time.RFC3339Nano)}
+}
+
+// Err is written as the error's message under the key "error".
+func Err(err error) Attr {
+	if err == nil {
+		return Attr{Key: "error", Value: nil}
+	}
+	return Attr{Key: "error", Value: err.Error()}
+}
+
+// Group nests attrs in an object under key. With an empty key the attrs are
+// written inline.
+func Group(key string, attrs ...Attr) Attr {
+	return Attr{Key: key, Value: group(attrs)}
+}
+
+// Any writes value with encoding/json.
+func Any(key string, value any) Attr {
+	return Attr{Key: key, Value: value}
+}
+
+type group []Attr
+
+// attrMap turns attrs into a map for encoding, with groups as nested maps.
+// When a key repeats, the last value wins, except that groups with the same
+// key, as happens when a child logger and an entry both use one, are merged
+// at every level.
+func attrMap(attrs []Attr) map[string]any {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	m := make(map[string]any, len(attrs))
+	for _, attr := range attrs {
+		if g, ok := attr.Value.(group); ok {
+			nested := attrMap(g)
+			if nested == nil {
+				continue
+			}
+			// An unnamed group's attributes are written inline.
+			if attr.Key == "" {
+				mergeMaps(m, nested)
+				continue
+			}
+			if existing, ok := m[attr.Key].(map[string]any); ok {
+				mergeMaps(existing, nested)
+				continue
+			}
+			m[attr.Key] = nested
+			continue
+		}
+		m[attr.Key] = attr.Value
+	}
+	return m
+}
+
+// mergeMaps copies src into dst, merging the groups both have rather than
+// replacing one with the other.
+func mergeMaps(dst, src map[string]any) {
+	for k, v := range src {
+		if nested, ok := v.(map[string]any); ok {
+			if existing, ok := dst[k].(map[string]any); ok {
+				mergeMaps(existing, nested)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
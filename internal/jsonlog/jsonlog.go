@@ -2,83 +2,191 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"os"
 	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-	"os"
-	"io"	
 )
 
-
 type Level int8
 
 const (
-	LevelInfo  Level = iota // Has the value 0.
-	LevelError              // Has the value 1.
-	LevelFatal              // Has the value 2.
-	LevelOff                // Has the value 3.
+	LevelDebug Level = iota // Has the value 0.
+	LevelInfo               // Has the value 1.
+	LevelWarn               // Has the value 2.
+	LevelError              // Has the value 3.
+	LevelFatal              // Has the value 4.
+	LevelOff                // Has the value 5.
 )
 
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 }
 
+// ParseLevel returns the level named by s, ignoring case.
+func ParseLevel(s string) (Level, error) {
+	for l := LevelDebug; l <= LevelOff; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// Set implements flag.Value, so a Level can be used as a flag directly.
+func (l *Level) Set(s string) error {
+	level, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// sink is shared by a Logger and every child created from it with With, so
+// that writes are serialised and level changes apply to all of them.
+type sink struct {
+	out        io.Writer
+	mu         sync.Mutex
+	minLevel   atomic.Int32
+	traceLevel atomic.Int32
+	sampler    atomic.Pointer[sampler]
+	sampled    atomic.Uint64
+}
+
 type Logger struct {
-	out      io.Writer
-	minLevel Level
-	mu       sync.Mutex
+	sink      *sink
+	attrs     []Attr
+	unsampled bool
 }
 
 func New(out io.Writer, minLevel Level) *Logger {
+	l := &Logger{
+		sink: &sink{out: out},
+	}
+	l.SetLevel(minLevel)
+	l.SetTraceLevel(LevelError)
+	return l
+}
+
+// SetLevel changes the minimum level written. It is safe to call while
+// other goroutines are logging, and applies to child loggers too.
+func (l *Logger) SetLevel(level Level) {
+	l.sink.minLevel.Store(int32(level))
+}
+
+func (l *Logger) Level() Level {
+	return Level(l.sink.minLevel.Load())
+}
+
+// SetTraceLevel sets the lowest level whose entries include a stack trace.
+// LevelOff turns stack traces off.
+func (l *Logger) SetTraceLevel(level Level) {
+	l.sink.traceLevel.Store(int32(level))
+}
+
+// Enabled reports whether entries at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level() && level < LevelOff
+}
+
+// With returns a child logger that adds attrs to every entry it writes.
+func (l *Logger) With(attrs ...Attr) *Logger {
 	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+		sink:      l.sink,
+		attrs:     append(append([]Attr(nil), l.attrs...), attrs...),
+		unsampled: l.unsampled,
 	}
 }
 
+func (l *Logger) Debug(message string, attrs ...Attr) {
+	l.Log(LevelDebug, message, attrs...)
+}
+
+func (l *Logger) Info(message string, attrs ...Attr) {
+	l.Log(LevelInfo, message, attrs...)
+}
+
+func (l *Logger) Warn(message string, attrs ...Attr) {
+	l.Log(LevelWarn, message, attrs...)
+}
+
+func (l *Logger) Error(message string, attrs ...Attr) {
+	l.Log(LevelError, message, attrs...)
+}
+
+func (l *Logger) Log(level Level, message string, attrs ...Attr) {
+	l.print(level, message, attrs)
+}
+
+// PrintInfo, PrintError and PrintFatal take their properties as strings.
+// New code should prefer Info and Error with typed attributes.
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
-	l.print(LevelInfo, message, properties)
+	l.print(LevelInfo, message, stringAttrs(properties))
 }
 func (l *Logger) PrintError(err error, properties map[string]string) {
-	l.print(LevelError, err.Error(), properties)
+	l.print(LevelError, err.Error(), stringAttrs(properties))
 }
 func (l *Logger) PrintFatal(err error, properties map[string]string) {
-	l.print(LevelFatal, err.Error(), properties)
+	l.print(LevelFatal, err.Error(), stringAttrs(properties))
+	flushOutput(l.sink.out)
 	os.Exit(1)
 }
 
-func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+func stringAttrs(properties map[string]string) []Attr {
+	attrs := make([]Attr, 0, len(properties))
+	for key, value := range properties {
+		attrs = append(attrs, String(key, value))
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		return attrs[i].Key < attrs[j].Key
+	})
+	return attrs
+}
+
+func (l *Logger) print(level Level, message string, attrs []Attr) (int, error) {
 
-	if level < l.minLevel {
+	if !l.Enabled(level) || !l.sample(level, message) {
 		return 0, nil
 	}
 
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string         `json:"level"`
+		Time       string         `json:"time"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties,omitempty"`
+		Trace      string         `json:"trace,omitempty"`
 	}{
 		Level:      level.String(),
 		Time:       time.Now().UTC().Format(time.RFC3339),
 		Message:    message,
-		Properties: properties,
+		Properties: attrMap(append(append([]Attr(nil), l.attrs...), attrs...)),
 	}
-	
-	if level >= LevelError {
+
+	if level >= Level(l.sink.traceLevel.Load()) {
 		aux.Trace = string(debug.Stack())
 	}
-	
+
 	var line []byte
 
 	line, err := json.Marshal(aux)
@@ -86,9 +194,9 @@ func (l *Logger) print(level Level, message string, properties map[string]string
 		line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
 	}
 
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	return l.out.Write(append(line, '\n'))
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	return writeLevel(l.sink.out, level, append(line, '\n'))
 }
 
 func (l *Logger) Write(message []byte) (n int, err error) {
@@ -0,0 +1,340 @@
+package jsonlog
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RotateOptions control when a RotatingFile starts a new file and how many
+// old ones it keeps. Zero values turn the corresponding limit off.
+type RotateOptions struct {
+	// MaxSize is the size in bytes a file may grow to before it is rotated.
+	MaxSize int64
+	// Interval is how long a file is written to before it is rotated.
+	Interval time.Duration
+	// MaxBackups is the number of rotated files kept.
+	MaxBackups int
+	// MaxAge is how long rotated files are kept.
+	MaxAge time.Duration
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// backupTimeFormat sorts in time order, which retention relies on.
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile is an output that writes to a file and renames it aside, as
+// name-<time>.ext, when it grows too large or too old. Compressing and
+// removing rotated files happens in the background.
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+	now  func() time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	closed bool
+
+	// mill serialises the background work on rotated files.
+	mill   sync.Mutex
+	milled sync.WaitGroup
+	// millErr is what went wrong in the last background run, held until the
+	// next Write or Close reports it.
+	millErr atomic.Pointer[error]
+}
+
+// OpenRotatingFile opens path for appending, creating it and its directory
+// if necessary.
+func OpenRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	// Rotated files are found by comparing the directory's entries, joined
+	// to it, with the path, so both must be in the same clean form.
+	path = filepath.Clean(path)
+
+	r := &RotatingFile{
+		path: path,
+		opts: opts,
+		now:  time.Now,
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = info.Size()
+	r.opened = r.now()
+	return nil
+}
+
+var errFileClosed = errors.New("jsonlog: write to closed file")
+
+// Write appends p to the file, rotating it first if it's due. A failed
+// rotation is reported, but p is still written as long as the file could be
+// reopened; if it couldn't, the next Write tries again. Failures in the
+// background work on rotated files are reported by the next Write too.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.reopen()
+	if err != nil {
+		return 0, err
+	}
+
+	var rotateErr error
+	if r.due(len(p)) {
+		rotateErr = r.rotate()
+		if r.file == nil {
+			return 0, rotateErr
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, errors.Join(rotateErr, r.millError(), err)
+}
+
+// reopen opens the file again if an earlier rotation left it closed.
+func (r *RotatingFile) reopen() error {
+	if r.closed {
+		return errFileClosed
+	}
+	if r.file == nil {
+		return r.open()
+	}
+	return nil
+}
+
+// due reports whether writing n more bytes should go to a new file. A file
+// is never rotated while empty, so a single oversized entry still gets
+// written.
+func (r *RotatingFile) due(n int) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.opts.MaxSize > 0 && r.size+int64(n) > r.opts.MaxSize {
+		return true
+	}
+	return r.opts.Interval > 0 && r.now().Sub(r.opened) >= r.opts.Interval
+}
+
+// Rotate starts a new file straight away.
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.reopen()
+	if err != nil {
+		return err
+	}
+	return r.rotate()
+}
+
+// rotate renames the file aside and opens a new one. If the rename fails
+// the original file is reopened, so logging carries on there.
+func (r *RotatingFile) rotate() error {
+	err := r.file.Close()
+	r.file = nil
+	if err != nil {
+		return errors.Join(err, r.open())
+	}
+
+	err = os.Rename(r.path, r.backupName(r.now()))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Join(err, r.open())
+	}
+
+	err = r.open()
+	if err != nil {
+		return err
+	}
+
+	r.milled.Add(1)
+	go func() {
+		defer r.milled.Done()
+		if err := r.millBackups(); err != nil {
+			r.millErr.Store(&err)
+		}
+	}()
+
+	return nil
+}
+
+func (r *RotatingFile) split() (prefix, ext string) {
+	ext = filepath.Ext(r.path)
+	return strings.TrimSuffix(r.path, ext) + "-", ext
+}
+
+func (r *RotatingFile) backupName(t time.Time) string {
+	prefix, ext := r.split()
+	return prefix + t.UTC().Format(backupTimeFormat) + ext
+}
+
+type backup struct {
+	path       string
+	time       time.Time
+	compressed bool
+}
+
+// backups lists the rotated files, oldest first.
+func (r *RotatingFile) backups() ([]backup, error) {
+	prefix, ext := r.split()
+
+	entries, err := os.ReadDir(filepath.Dir(r.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []backup
+	for _, entry := range entries {
+		path := filepath.Join(filepath.Dir(r.path), entry.Name())
+		if entry.IsDir() || !strings.HasPrefix(path, prefix) {
+			continue
+		}
+
+		b := backup{path: path}
+		name := strings.TrimPrefix(path, prefix)
+		if strings.HasSuffix(name, ".gz") {
+			name = strings.TrimSuffix(name, ".gz")
+			b.compressed = true
+		}
+		if !strings.HasSuffix(name, ext) {
+			continue
+		}
+
+		b.time, err = time.Parse(backupTimeFormat, strings.TrimSuffix(name, ext))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, b)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.Before(backups[j].time)
+	})
+
+	return backups, nil
+}
+
+// millBackups compresses rotated files and removes the ones that are past
+// the retention limits. It carries on past failures, which the next
+// rotation retries, and returns them all.
+func (r *RotatingFile) millBackups() error {
+	r.mill.Lock()
+	defer r.mill.Unlock()
+
+	backups, err := r.backups()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	keep := backups[:0]
+	for i, b := range backups {
+		expired := r.opts.MaxAge > 0 && r.now().Sub(b.time) > r.opts.MaxAge
+		excess := r.opts.MaxBackups > 0 && len(backups)-i > r.opts.MaxBackups
+		if expired || excess {
+			errs = append(errs, os.Remove(b.path))
+			continue
+		}
+		keep = append(keep, b)
+	}
+
+	if r.opts.Compress {
+		for _, b := range keep {
+			if !b.compressed {
+				errs = append(errs, compressFile(b.path))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// millError returns the error from the last background run on rotated
+// files, if there was one and it hasn't been reported yet.
+func (r *RotatingFile) millError() error {
+	if err := r.millErr.Swap(nil); err != nil {
+		return *err
+	}
+	return nil
+}
+
+// compressFile replaces path with a gzipped copy, path.gz.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Close closes the file and waits for any background work on rotated files
+// to finish, returning its error if the work failed.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.milled.Wait()
+
+	r.closed = true
+	if r.file == nil {
+		return r.millError()
+	}
+
+	err := r.file.Close()
+	r.file = nil
+	return errors.Join(r.millError(), err)
+}
@@ -0,0 +1,88 @@
+package jsonlog
+
+import (
+	"sync"
+	"time"
+)
+
+// Sampling limits how often the same entry is written, to keep a burst of
+// repetitive lines from swamping the output. Within each Tick, the first
+// First entries with a given level and message are written, and after that
+// only every Thereafter-th. Entries at LevelWarn and above, and those
+// written through an Unsampled logger, are never sampled. A zero Tick turns
+// sampling off.
+type Sampling struct {
+	Tick       time.Duration
+	First      int
+	Thereafter int
+}
+
+type sampleKey struct {
+	level   Level
+	message string
+}
+
+type sampler struct {
+	Sampling
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[sampleKey]int
+}
+
+// allow reports whether an entry should be written, counting it either way.
+func (s *sampler) allow(level Level, message string, now time.Time) bool {
+	if level >= LevelWarn {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.start) >= s.Tick {
+		s.start = now
+		s.counts = make(map[sampleKey]int)
+	}
+
+	key := sampleKey{level, message}
+	s.counts[key]++
+	n := s.counts[key]
+
+	if n <= s.First {
+		return true
+	}
+	return s.Thereafter > 0 && (n-s.First)%s.Thereafter == 0
+}
+
+// SetSampling changes the sampling policy. It is safe to call while other
+// goroutines are logging, and applies to child loggers too.
+func (l *Logger) SetSampling(sampling Sampling) {
+	if sampling.Tick <= 0 {
+		l.sink.sampler.Store(nil)
+		return
+	}
+	l.sink.sampler.Store(&sampler{Sampling: sampling})
+}
+
+// Unsampled returns a child logger whose entries are never sampled, for
+// logs such as the access log where every entry is wanted even though they
+// all share a message.
+func (l *Logger) Unsampled() *Logger {
+	child := l.With()
+	child.unsampled = true
+	return child
+}
+
+// Sampled returns the number of entries that sampling has discarded.
+func (l *Logger) Sampled() uint64 {
+	return l.sink.sampled.Load()
+}
+
+func (l *Logger) sample(level Level, message string) bool {
+	s := l.sink.sampler.Load()
+	if s == nil || l.unsampled || s.allow(level, message, time.Now()) {
+		return true
+	}
+	l.sink.sampled.Add(1)
+	return false
+}
@@ -0,0 +1,213 @@
+package jsonlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// LevelWriter is implemented by outputs that treat entries differently
+// depending on their level, such as Syslog. The Logger calls WriteLevel
+// instead of Write when its output implements it.
+type LevelWriter interface {
+	WriteLevel(level Level, p []byte) (int, error)
+}
+
+func writeLevel(w io.Writer, level Level, p []byte) (int, error) {
+	if lw, ok := w.(LevelWriter); ok {
+		return lw.WriteLevel(level, p)
+	}
+	return w.Write(p)
+}
+
+// flusher is implemented by outputs that buffer, so that PrintFatal can
+// flush them before the process exits.
+type flusher interface {
+	Flush() error
+}
+
+func flushOutput(w io.Writer) error {
+	if f, ok := w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+type multiWriter struct {
+	writers []io.Writer
+}
+
+// MultiWriter returns an output that writes each entry to every writer,
+// passing levels through to the ones that implement LevelWriter. A failing
+// writer doesn't stop the others from being written to.
+func MultiWriter(writers ...io.Writer) io.Writer {
+	return &multiWriter{writers: writers}
+}
+
+func (m *multiWriter) Write(p []byte) (int, error) {
+	return m.WriteLevel(LevelInfo, p)
+}
+
+func (m *multiWriter) WriteLevel(level Level, p []byte) (int, error) {
+	var errs []error
+	for _, w := range m.writers {
+		_, err := writeLevel(w, level, p)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(p), errors.Join(errs...)
+}
+
+func (m *multiWriter) Flush() error {
+	var errs []error
+	for _, w := range m.writers {
+		errs = append(errs, flushOutput(w))
+	}
+	return errors.Join(errs...)
+}
+
+type queuedEntry struct {
+	level Level
+	line  []byte
+}
+
+// AsyncWriter hands entries to a background goroutine that writes them to
+// another output, so that a slow output doesn't hold up the goroutines that
+// log. When the queue is full new entries below LevelWarn are dropped and
+// counted instead; warnings and errors wait for room, since they are the
+// entries most needed when the output falls behind.
+type AsyncWriter struct {
+	out     io.Writer
+	queue   chan queuedEntry
+	flush   chan chan struct{}
+	closing chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+
+	// onError is told about failed writes, which have nowhere else to go.
+	onError func(error)
+}
+
+// NewAsyncWriter starts the goroutine that writes to out, queueing up to
+// size entries.
+func NewAsyncWriter(out io.Writer, size int) *AsyncWriter {
+	w := &AsyncWriter{
+		out:     out,
+		queue:   make(chan queuedEntry, size),
+		flush:   make(chan chan struct{}),
+		closing: make(chan struct{}),
+		stopped: make(chan struct{}),
+		onError: func(err error) {
+			fmt.Fprintf(os.Stderr, "jsonlog: %v\n", err)
+		},
+	}
+
+	go w.run()
+
+	return w
+}
+
+func (w *AsyncWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(LevelInfo, p)
+}
+
+func (w *AsyncWriter) WriteLevel(level Level, p []byte) (int, error) {
+	entry := queuedEntry{level: level, line: append([]byte(nil), p...)}
+
+	// Once closed there's no goroutine left to write for us, so warnings
+	// and errors are written directly.
+	select {
+	case <-w.closing:
+		if level >= LevelWarn {
+			<-w.stopped
+			return writeLevel(w.out, level, p)
+		}
+		w.dropped.Add(1)
+		return len(p), nil
+	default:
+	}
+
+	if level >= LevelWarn {
+		select {
+		case w.queue <- entry:
+		case <-w.stopped:
+			return writeLevel(w.out, level, p)
+		}
+		return len(p), nil
+	}
+
+	select {
+	case w.queue <- entry:
+	default:
+		w.dropped.Add(1)
+	}
+
+	return len(p), nil
+}
+
+// Dropped returns the number of entries below LevelWarn that have been
+// discarded because the queue was full.
+func (w *AsyncWriter) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+func (w *AsyncWriter) run() {
+	defer close(w.stopped)
+
+	write := func(entry queuedEntry) {
+		_, err := writeLevel(w.out, entry.level, entry.line)
+		if err != nil {
+			w.onError(err)
+		}
+	}
+
+	drain := func() {
+		for {
+			select {
+			case entry := <-w.queue:
+				write(entry)
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case entry := <-w.queue:
+			write(entry)
+		case ack := <-w.flush:
+			drain()
+			close(ack)
+		case <-w.closing:
+			drain()
+			return
+		}
+	}
+}
+
+// Flush waits until everything queued so far has been written.
+func (w *AsyncWriter) Flush() error {
+	ack := make(chan struct{})
+
+	select {
+	case w.flush <- ack:
+		<-ack
+	case <-w.stopped:
+	}
+
+	return nil
+}
+
+// Close writes out the queue and stops the goroutine. Entries written after
+// Close are dropped. It doesn't close the underlying output.
+func (w *AsyncWriter) Close() error {
+	w.once.Do(func() { close(w.closing) })
+	<-w.stopped
+	return nil
+}
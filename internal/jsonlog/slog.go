@@ -0,0 +1,139 @@
+package jsonlog
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Handler returns an slog.Handler that writes through l, so that code using
+// log/slog ends up in the same log with the same format and level.
+func (l *Logger) Handler() slog.Handler {
+	return &handler{logger: l}
+}
+
+type handler struct {
+	logger *Logger
+	groups []string
+	// attrs holds attributes added with WithAttrs inside the innermost group.
+	// Attributes added before the first group are bound to logger instead.
+	attrs []Attr
+}
+
+func levelFromSlog(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Enabled(levelFromSlog(level))
+}
+
+func (h *handler) Handle(_ context.Context, record slog.Record) error {
+	attrs := append([]Attr(nil), h.attrs...)
+	record.Attrs(func(a slog.Attr) bool {
+		if attr, ok := fromSlog(a); ok {
+			attrs = append(attrs, attr)
+		}
+		return true
+	})
+
+	_, err := h.logger.print(levelFromSlog(record.Level), record.Message, h.nest(attrs))
+	return err
+}
+
+// nest wraps attrs in the handler's groups, innermost last.
+func (h *handler) nest(attrs []Attr) []Attr {
+	for i := len(h.groups) - 1; i >= 0; i-- {
+		if len(attrs) == 0 {
+			return nil
+		}
+		attrs = []Attr{Group(h.groups[i], attrs...)}
+	}
+	return attrs
+}
+
+func (h *handler) WithAttrs(as []slog.Attr) slog.Handler {
+	var attrs []Attr
+	for _, a := range as {
+		if attr, ok := fromSlog(a); ok {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	if len(h.groups) == 0 {
+		return &handler{logger: h.logger.With(attrs...)}
+	}
+
+	return &handler{
+		logger: h.logger,
+		groups: h.groups,
+		attrs:  append(append([]Attr(nil), h.attrs...), attrs...),
+	}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	// Attributes already bound inside the current group stay there, so they
+	// are moved into the logger before the new group is opened.
+	logger := h.logger
+	if len(h.attrs) > 0 {
+		logger = logger.With(h.nest(h.attrs)...)
+	}
+
+	return &handler{
+		logger: logger,
+		groups: append(append([]string(nil), h.groups...), name),
+	}
+}
+
+// fromSlog converts a, dropping it as slog.Handler implementations should
+// when it is empty.
+func fromSlog(a slog.Attr) (Attr, bool) {
+	v := a.Value.Resolve()
+
+	switch v.Kind() {
+	case slog.KindGroup:
+		var attrs []Attr
+		for _, ga := range v.Group() {
+			if attr, ok := fromSlog(ga); ok {
+				attrs = append(attrs, attr)
+			}
+		}
+		if len(attrs) == 0 {
+			return Attr{}, false
+		}
+		// An unnamed group's attributes belong to the enclosing object, which
+		// Group with an empty key gives.
+		return Group(a.Key, attrs...), true
+	case slog.KindString:
+		return String(a.Key, v.String()), a.Key != ""
+	case slog.KindInt64:
+		return Int64(a.Key, v.Int64()), a.Key != ""
+	case slog.KindUint64:
+		return Any(a.Key, v.Uint64()), a.Key != ""
+	case slog.KindFloat64:
+		return Float64(a.Key, v.Float64()), a.Key != ""
+	case slog.KindBool:
+		return Bool(a.Key, v.Bool()), a.Key != ""
+	case slog.KindDuration:
+		return Duration(a.Key, v.Duration()), a.Key != ""
+	case slog.KindTime:
+		return Time(a.Key, v.Time()), a.Key != ""
+	default:
+		if err, ok := v.Any().(error); ok {
+			return String(a.Key, err.Error()), a.Key != ""
+		}
+		return Any(a.Key, v.Any()), a.Key != ""
+	}
+}
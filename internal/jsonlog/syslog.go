@@ -0,0 +1,145 @@
+package jsonlog
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// syslogDaemon is the syslog facility entries are sent with.
+const syslogDaemon = 3 << 3
+
+// syslogSockets are where the local syslog daemon usually listens.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// Syslog is an output that sends entries to the local syslog daemon over
+// its Unix socket, with a severity that matches each entry's level.
+type Syslog struct {
+	addr string
+	tag  string
+	pid  string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// DialSyslog connects to the syslog socket at addr, or when addr is empty
+// to the first of the usual sockets that accepts a connection. Entries are
+// tagged with tag.
+func DialSyslog(addr, tag string) (*Syslog, error) {
+	s := &Syslog{
+		addr: addr,
+		tag:  tag,
+		pid:  strconv.Itoa(os.Getpid()),
+	}
+
+	err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+var errNoSyslog = errors.New("jsonlog: no syslog socket found")
+
+func (s *Syslog) connect() error {
+	addrs := syslogSockets
+	if s.addr != "" {
+		addrs = []string{s.addr}
+	}
+
+	var lastErr error = errNoSyslog
+	for _, addr := range addrs {
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.Dial(network, addr)
+			if err == nil {
+				s.addr = addr
+				s.conn = conn
+				return nil
+			}
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+func syslogSeverity(level Level) int {
+	switch level {
+	case LevelDebug:
+		return 7
+	case LevelInfo:
+		return 6
+	case LevelWarn:
+		return 4
+	case LevelError:
+		return 3
+	default:
+		return 2
+	}
+}
+
+func (s *Syslog) Write(p []byte) (int, error) {
+	return s.WriteLevel(LevelInfo, p)
+}
+
+// WriteLevel sends p in the traditional local format, which every syslog
+// daemon understands: <priority>timestamp tag[pid]: message.
+func (s *Syslog) WriteLevel(level Level, p []byte) (int, error) {
+	msg := make([]byte, 0, len(p)+64)
+	msg = append(msg, '<')
+	msg = strconv.AppendInt(msg, int64(syslogDaemon|syslogSeverity(level)), 10)
+	msg = append(msg, '>')
+	msg = time.Now().AppendFormat(msg, time.Stamp)
+	msg = append(msg, ' ')
+	msg = append(msg, s.tag...)
+	msg = append(msg, '[')
+	msg = append(msg, s.pid...)
+	msg = append(msg, "]: "...)
+	msg = append(msg, p...)
+	if len(p) == 0 || p[len(p)-1] != '\n' {
+		msg = append(msg, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The daemon may have restarted since the last write, so try once more
+	// on a new connection before giving up.
+	if s.conn != nil {
+		_, err := s.conn.Write(msg)
+		if err == nil {
+			return len(p), nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	err := s.connect()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.conn.Write(msg)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
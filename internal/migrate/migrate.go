@@ -0,0 +1,357 @@
+// Package migrate applies the SQL migrations in a filesystem to a PostgreSQL
+// database. It keeps its state in the same schema_migrations table as the
+// golang-migrate CLI, so databases migrated with either tool stay compatible.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// lockID is the key of the advisory lock held while migrating, so that two
+// instances starting at once don't both try to apply the same migration.
+const lockID int64 = 7326451208
+
+var (
+	ErrDirty          = errors.New("migrate: database is dirty; fix the failed migration by hand and reset schema_migrations")
+	ErrUnknownVersion = errors.New("migrate: unknown migration version")
+)
+
+var fileRX = regexp.MustCompile(`^([0-9]+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Current int64
+	Latest  int64
+	Dirty   bool
+	Pending []Migration
+}
+
+// Behind reports whether there are migrations that have not been applied.
+func (s Status) Behind() bool {
+	return len(s.Pending) > 0
+}
+
+// Load reads the NNNNNN_name.up.sql and NNNNNN_name.down.sql files at the
+// root of fsys and returns them sorted by version. Every version needs both
+// an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		matches := fileRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %s: %w", entry.Name(), err)
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		} else if m.Name != matches[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by both %q and %q", version, m.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrate: version %d (%s) needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Status reports the applied version and the migrations still to run.
+func (m *Migrator) Status() (Status, error) {
+	var status Status
+
+	err := m.withLock(func(conn *sql.Conn) error {
+		current, dirty, err := readVersion(conn)
+		if err != nil {
+			return err
+		}
+
+		status = m.status(current, dirty)
+		return nil
+	})
+
+	return status, err
+}
+
+// Peek is like Status but doesn't take the migration lock or create the
+// schema_migrations table, so that health checks neither wait behind a
+// running migration nor change the database. A database that has never
+// been migrated is at version 0.
+func (m *Migrator) Peek(ctx context.Context) (Status, error) {
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return Status{}, err
+	}
+
+	if !exists {
+		return m.status(0, false), nil
+	}
+
+	var version int64
+	var dirty bool
+	err = m.DB.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Status{}, err
+	}
+
+	return m.status(version, dirty), nil
+}
+
+// Up applies every pending migration and returns the ones it ran.
+func (m *Migrator) Up() ([]Migration, error) {
+	if len(m.Migrations) == 0 {
+		return nil, nil
+	}
+
+	return m.To(m.Migrations[len(m.Migrations)-1].Version)
+}
+
+// Down rolls back the most recently applied migration. It returns nothing if
+// no migrations have been applied.
+func (m *Migrator) Down() ([]Migration, error) {
+	var ran []Migration
+
+	err := m.withLock(func(conn *sql.Conn) error {
+		current, dirty, err := readVersion(conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+		if current == 0 {
+			return nil
+		}
+
+		ran, err = m.migrate(conn, current, m.previous(current))
+		return err
+	})
+
+	return ran, err
+}
+
+// To migrates up or down until version is the applied version. A version of
+// 0 rolls back every migration.
+func (m *Migrator) To(version int64) ([]Migration, error) {
+	if version != 0 && m.index(version) < 0 {
+		return nil, fmt.Errorf("%w %d", ErrUnknownVersion, version)
+	}
+
+	var ran []Migration
+
+	err := m.withLock(func(conn *sql.Conn) error {
+		current, dirty, err := readVersion(conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return ErrDirty
+		}
+
+		ran, err = m.migrate(conn, current, version)
+		return err
+	})
+
+	return ran, err
+}
+
+func (m *Migrator) status(current int64, dirty bool) Status {
+	status := Status{Current: current, Dirty: dirty}
+
+	for _, migration := range m.Migrations {
+		status.Latest = migration.Version
+		if migration.Version > current {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+
+	return status
+}
+
+// migrate runs the migrations between from and to, each in its own
+// transaction which also records the new version. If one fails the database
+// is left at the last version that succeeded.
+func (m *Migrator) migrate(conn *sql.Conn, from, to int64) ([]Migration, error) {
+	var ran []Migration
+
+	if from != 0 && m.index(from) < 0 {
+		return nil, fmt.Errorf("%w %d in schema_migrations", ErrUnknownVersion, from)
+	}
+
+	if to >= from {
+		for _, migration := range m.Migrations {
+			if migration.Version <= from || migration.Version > to {
+				continue
+			}
+
+			err := apply(conn, migration.Up, migration.Version)
+			if err != nil {
+				return ran, fmt.Errorf("migrate: %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+			ran = append(ran, migration)
+		}
+		return ran, nil
+	}
+
+	for i := m.index(from); i >= 0 && m.Migrations[i].Version > to; i-- {
+		migration := m.Migrations[i]
+
+		err := apply(conn, migration.Down, m.previous(migration.Version))
+		if err != nil {
+			return ran, fmt.Errorf("migrate: %d_%s down: %w", migration.Version, migration.Name, err)
+		}
+		ran = append(ran, migration)
+	}
+
+	return ran, nil
+}
+
+// index returns the position of version in m.Migrations, or -1.
+func (m *Migrator) index(version int64) int {
+	for i, migration := range m.Migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// previous returns the version before version, or 0 for the first one.
+func (m *Migrator) previous(version int64) int64 {
+	i := m.index(version)
+	if i <= 0 {
+		return 0
+	}
+	return m.Migrations[i-1].Version
+}
+
+// withLock runs fn on a single connection holding the migration advisory
+// lock, creating the schema_migrations table first if it doesn't exist. It
+// waits for any other runner to finish rather than failing.
+func (m *Migrator) withLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, lockID)
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func readVersion(conn *sql.Conn) (int64, bool, error) {
+	var version int64
+	var dirty bool
+
+	err := conn.QueryRowContext(context.Background(), `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
+
+func apply(conn *sql.Conn, query string, version int64) error {
+	ctx := context.Background()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	if version != 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
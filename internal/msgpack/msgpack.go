@@ -0,0 +1,106 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Writer emits MessagePack values one at a time. Arrays and maps are written
+// as a header giving their length followed by that many values (or, for
+// maps, key/value pairs).
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Err returns the first error returned by the underlying writer.
+func (mw *Writer) Err() error {
+	return mw.err
+}
+
+func (mw *Writer) WriteNil() {
+	mw.write(0xc0)
+}
+
+func (mw *Writer) WriteBool(b bool) {
+	if b {
+		mw.write(0xc3)
+		return
+	}
+	mw.write(0xc2)
+}
+
+func (mw *Writer) WriteInt(i int64) {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		mw.write(byte(i))
+	case i < 0 && i >= -32:
+		mw.write(byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		mw.write(0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		mw.write(0xd1)
+		mw.write(binary.BigEndian.AppendUint16(nil, uint16(i))...)
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		mw.write(0xd2)
+		mw.write(binary.BigEndian.AppendUint32(nil, uint32(i))...)
+	default:
+		mw.write(0xd3)
+		mw.write(binary.BigEndian.AppendUint64(nil, uint64(i))...)
+	}
+}
+
+func (mw *Writer) WriteFloat(f float64) {
+	mw.write(0xcb)
+	mw.write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f))...)
+}
+
+func (mw *Writer) WriteString(s string) {
+	n := len(s)
+	switch {
+	case n <= 31:
+		mw.write(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		mw.write(0xd9, byte(n))
+	case n <= math.MaxUint16:
+		mw.write(0xda)
+		mw.write(binary.BigEndian.AppendUint16(nil, uint16(n))...)
+	default:
+		mw.write(0xdb)
+		mw.write(binary.BigEndian.AppendUint32(nil, uint32(n))...)
+	}
+	mw.write([]byte(s)...)
+}
+
+func (mw *Writer) WriteArrayHeader(n int) {
+	mw.writeHeader(n, 0x90, 0xdc, 0xdd)
+}
+
+func (mw *Writer) WriteMapHeader(n int) {
+	mw.writeHeader(n, 0x80, 0xde, 0xdf)
+}
+
+func (mw *Writer) writeHeader(n int, fix, b16, b32 byte) {
+	switch {
+	case n <= 15:
+		mw.write(fix | byte(n))
+	case n <= math.MaxUint16:
+		mw.write(b16)
+		mw.write(binary.BigEndian.AppendUint16(nil, uint16(n))...)
+	default:
+		mw.write(b32)
+		mw.write(binary.BigEndian.AppendUint32(nil, uint32(n))...)
+	}
+}
+
+func (mw *Writer) write(b ...byte) {
+	if mw.err != nil {
+		return
+	}
+	_, mw.err = mw.w.Write(b)
+}
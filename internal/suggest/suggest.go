@@ -0,0 +1,236 @@
+package suggest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	KindTitle = "title"
+	KindGenre = "genre"
+)
+
+// maxScan caps how many index entries a single lookup examines, so that a
+// one-letter prefix on a large catalogue still answers quickly.
+const maxScan = 1000
+
+type Suggestion struct {
+	Text    string `json:"text"`
+	Kind    string `json:"kind"`
+	MovieID int64  `json:"movie_id,omitempty"`
+}
+
+type entry struct {
+	key     string
+	movieID int64
+	// offset is the word the key starts at, 0 for the start of the title.
+	offset int
+}
+
+type movie struct {
+	title  string
+	genres []string
+}
+
+// Item is a movie to be indexed by Load.
+type Item struct {
+	ID     int64
+	Title  string
+	Genres []string
+}
+
+// Index answers prefix lookups over movie titles and genres. Titles match
+// from the start of any word, so "wars" suggests "Star Wars". It is safe
+// for concurrent use.
+type Index struct {
+	mu      sync.RWMutex
+	entries []entry
+	movies  map[int64]movie
+	genres  map[string]int
+}
+
+func New() *Index {
+	return &Index{
+		movies: make(map[int64]movie),
+		genres: make(map[string]int),
+	}
+}
+
+// Set adds the movie with the given id, replacing it if it's already
+// indexed.
+func (idx *Index) Set(id int64, title string, genres []string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.delete(id)
+
+	for _, e := range titleEntries(id, title) {
+		pos := sort.Search(len(idx.entries), func(j int) bool { return !idx.entries[j].less(e) })
+		idx.entries = append(idx.entries, entry{})
+		copy(idx.entries[pos+1:], idx.entries[pos:])
+		idx.entries[pos] = e
+	}
+
+	idx.addMovie(id, title, genres)
+}
+
+// Load adds every item, replacing any that are already indexed. It sorts
+// the index once at the end rather than inserting each title in place as
+// Set does, so it's the way to fill an index with a whole catalogue.
+func (idx *Index) Load(items []Item) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, item := range items {
+		idx.delete(item.ID)
+		idx.entries = append(idx.entries, titleEntries(item.ID, item.Title)...)
+		idx.addMovie(item.ID, item.Title, item.Genres)
+	}
+
+	sort.Slice(idx.entries, func(i, j int) bool { return idx.entries[i].less(idx.entries[j]) })
+}
+
+func (idx *Index) addMovie(id int64, title string, genres []string) {
+	for _, genre := range genres {
+		idx.genres[genre]++
+	}
+
+	idx.movies[id] = movie{title: title, genres: append([]string(nil), genres...)}
+}
+
+// titleEntries returns an entry for each word of title, keyed by the rest
+// of the title from that word on.
+func titleEntries(id int64, title string) []entry {
+	words := strings.Fields(normalize(title))
+
+	entries := make([]entry, len(words))
+	for i := range words {
+		entries[i] = entry{key: strings.Join(words[i:], " "), movieID: id, offset: i}
+	}
+	return entries
+}
+
+// Delete removes the movie with the given id, if it's indexed.
+func (idx *Index) Delete(id int64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.delete(id)
+}
+
+func (idx *Index) delete(id int64) {
+	m, ok := idx.movies[id]
+	if !ok {
+		return
+	}
+
+	kept := idx.entries[:0]
+	for _, e := range idx.entries {
+		if e.movieID != id {
+			kept = append(kept, e)
+		}
+	}
+	idx.entries = kept
+
+	for _, genre := range m.genres {
+		idx.genres[genre]--
+		if idx.genres[genre] <= 0 {
+			delete(idx.genres, genre)
+		}
+	}
+
+	delete(idx.movies, id)
+}
+
+// Suggest returns up to limit suggestions for prefix. Genres come first,
+// most used first, then titles: those starting with prefix before those
+// with a later word starting with it, and shorter titles first.
+func (idx *Index) Suggest(prefix string, limit int) []Suggestion {
+	prefix = normalize(prefix)
+	suggestions := []Suggestion{}
+	if prefix == "" || limit <= 0 {
+		return suggestions
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var genres []string
+	for genre := range idx.genres {
+		if strings.HasPrefix(normalize(genre), prefix) {
+			genres = append(genres, genre)
+		}
+	}
+	sort.Slice(genres, func(i, j int) bool {
+		if idx.genres[genres[i]] != idx.genres[genres[j]] {
+			return idx.genres[genres[i]] > idx.genres[genres[j]]
+		}
+		return genres[i] < genres[j]
+	})
+	for _, genre := range genres {
+		if len(suggestions) == limit {
+			return suggestions
+		}
+		suggestions = append(suggestions, Suggestion{Text: genre, Kind: KindGenre})
+	}
+
+	start := sort.Search(len(idx.entries), func(i int) bool { return idx.entries[i].key >= prefix })
+
+	best := make(map[int64]int)
+	for i := start; i < len(idx.entries) && i-start < maxScan; i++ {
+		e := idx.entries[i]
+		if !strings.HasPrefix(e.key, prefix) {
+			break
+		}
+		if offset, ok := best[e.movieID]; !ok || e.offset < offset {
+			best[e.movieID] = e.offset
+		}
+	}
+
+	ids := make([]int64, 0, len(best))
+	for id := range best {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := ids[i], ids[j]
+		if (best[a] == 0) != (best[b] == 0) {
+			return best[a] == 0
+		}
+		if len(idx.movies[a].title) != len(idx.movies[b].title) {
+			return len(idx.movies[a].title) < len(idx.movies[b].title)
+		}
+		if idx.movies[a].title != idx.movies[b].title {
+			return idx.movies[a].title < idx.movies[b].title
+		}
+		return a < b
+	})
+
+	for _, id := range ids {
+		if len(suggestions) == limit {
+			break
+		}
+		suggestions = append(suggestions, Suggestion{Text: idx.movies[id].title, Kind: KindTitle, MovieID: id})
+	}
+
+	return suggestions
+}
+
+// Len returns the number of indexed movies.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return len(idx.movies)
+}
+
+func (e entry) less(other entry) bool {
+	if e.key != other.key {
+		return e.key < other.key
+	}
+	return e.movieID < other.movieID
+}
+
+func normalize(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
@@ -8,12 +8,16 @@ var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 )
 
+// Validator collects a message for each field that fails a check, along
+// with a stable machine-readable code for the failure. Checks that don't
+// give a code are recorded with the code "invalid".
 type Validator struct {
 	Errors map[string]string
+	Codes  map[string]string
 }
 
 func New() *Validator {
-	return &Validator{Errors: make(map[string]string)}
+	return &Validator{Errors: make(map[string]string), Codes: make(map[string]string)}
 }
 
 func (v *Validator) Valid() bool {
@@ -21,14 +25,23 @@ func (v *Validator) Valid() bool {
 }
 
 func (v *Validator) AddError(key, message string) {
+	v.AddErrorCode(key, "invalid", message)
+}
+
+func (v *Validator) AddErrorCode(key, code, message string) {
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
+		v.Codes[key] = code
 	}
 }
 
 func (v *Validator) Check(ok bool, key, message string) {
+	v.CheckCode(ok, key, "invalid", message)
+}
+
+func (v *Validator) CheckCode(ok bool, key, code, message string) {
 	if !ok {
-		v.AddError(key, message)
+		v.AddErrorCode(key, code, message)
 	}
 }
 
@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code IN ('movies:propose', 'movies:approve');
+DROP TABLE IF EXISTS movie_proposals;
\ No newline at end of file
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS movie_proposals (
+id bigserial PRIMARY KEY,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+movie_id bigint REFERENCES movies ON DELETE CASCADE,
+base_version integer NOT NULL DEFAULT 0,
+title text NOT NULL,
+year integer NOT NULL,
+runtime integer NOT NULL,
+genres text[] NOT NULL,
+status text NOT NULL DEFAULT 'pending',
+reviewer_id bigint REFERENCES users ON DELETE SET NULL,
+review_comment text NOT NULL DEFAULT '',
+reviewed_at timestamp(0) with time zone,
+version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS movie_proposals_status_idx ON movie_proposals (status);
+
+INSERT INTO permissions (code)
+VALUES
+('movies:propose'),
+('movies:approve');
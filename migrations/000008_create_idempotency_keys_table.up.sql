@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+user_id bigint NOT NULL,
+key text NOT NULL,
+fingerprint bytea NOT NULL,
+status integer NOT NULL DEFAULT 0,
+header jsonb NOT NULL DEFAULT '{}',
+body bytea NOT NULL DEFAULT '',
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+expiry timestamp(0) with time zone NOT NULL,
+PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expiry_idx ON idempotency_keys (expiry);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS movie_similarities;
\ No newline at end of file
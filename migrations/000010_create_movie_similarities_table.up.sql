@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS movie_similarities (
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+similar_id bigint NOT NULL,
+score real NOT NULL,
+PRIMARY KEY (movie_id, similar_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_similarities_score_idx ON movie_similarities (movie_id, score DESC);
+CREATE INDEX IF NOT EXISTS movie_similarities_similar_id_idx ON movie_similarities (similar_id);
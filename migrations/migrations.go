@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations so that the API binary can
+// apply them itself.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS